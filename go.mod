@@ -6,6 +6,8 @@ require (
 	github.com/matst80/slask-finder v0.0.0-20251001054432-9c1558528461
 	github.com/prometheus/client_golang v1.23.2
 	github.com/rabbitmq/amqp091-go v1.10.0
+	go.etcd.io/bbolt v1.4.3
+	golang.org/x/text v0.28.0
 )
 
 require (
@@ -8,50 +8,99 @@ import (
 	"strconv"
 	"strings"
 
-	"github.com/matst80/slask-finder/pkg/messaging"
+	"github.com/matst80/slask-tracking/pkg/broker"
+	"github.com/matst80/slask-tracking/pkg/eventlog"
 	"github.com/matst80/slask-tracking/pkg/events"
 	"github.com/matst80/slask-tracking/pkg/view"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
-	amqp "github.com/rabbitmq/amqp091-go"
 )
 
 var rabbitUrl = os.Getenv("RABBIT_URL")
 var country = "no"
 
-func init() {
+func createTransport() broker.Transport {
+	// the in memory bus consumes nothing from other services, it has to be
+	// asked for so a missing RABBIT_URL does not go unnoticed in production
+	if os.Getenv("TRANSPORT") == "memory" {
+		log.Println("Using in memory transport")
+		return broker.NewMemoryTransport(1024)
+	}
 	if rabbitUrl == "" {
-		log.Fatalf("RABBIT_URL environment variable is not set")
+		log.Fatalf("RABBIT_URL environment variable is not set, set TRANSPORT=memory to run without RabbitMQ")
+	}
+	transport := broker.NewRabbitTransport(rabbitUrl, "tracking", "sort_override", "field_sort_override")
+	transport.ConfigureQueue("tracking", broker.QueueConfig{
+		Queue:              os.Getenv("TRACKING_QUEUE"),
+		DeadLetterExchange: os.Getenv("TRACKING_DLX"),
+		Prefetch:           100,
+	})
+	return transport
+}
+
+func createStorage() view.Storage {
+	if os.Getenv("TRACKING_STORAGE") == "bolt" {
+		storage, err := view.OpenBoltStorage("data/tracking.db")
+		if err != nil {
+			log.Fatalf("Failed to open bolt storage: %v", err)
+		}
+		return storage
 	}
+	return view.NewJsonFileStorage("data/tracking.json")
 }
 
 func run_application() int {
 
-	conn, err := amqp.DialConfig(rabbitUrl, amqp.Config{
-		Properties: amqp.NewConnectionProperties(),
-	})
-	if err != nil {
-		log.Fatalf("Failed to connect to RabbitMQ: %v", err)
+	profilesPath := os.Getenv("DECAY_PROFILES")
+	if profilesPath == "" {
+		profilesPath = "data/decay-profiles.json"
 	}
-	ch, err := conn.Channel()
-	if err != nil {
-		log.Fatalf("Failed to open a channel: %v", err)
+	if err := view.LoadDecayProfiles(profilesPath); err != nil {
+		log.Fatalf("Failed to load decay profiles: %v", err)
 	}
-	err = messaging.DefineTopic(ch, "global", "sort_override")
-	if err != nil {
-		log.Fatalf("Failed to define topic: %v", err)
+	weightsPath := os.Getenv("EVENT_WEIGHTS")
+	if weightsPath == "" {
+		weightsPath = "data/event-weights.json"
+	}
+	if err := view.LoadEventWeights(weightsPath); err != nil {
+		log.Fatalf("Failed to load event weights: %v", err)
+	}
+	normalizationPath := os.Getenv("QUERY_NORMALIZATION")
+	if normalizationPath == "" {
+		normalizationPath = "data/query-normalization.json"
+	}
+	if err := view.LoadQueryNormalization(normalizationPath); err != nil {
+		log.Fatalf("Failed to load query normalization: %v", err)
+	}
+
+	transport := createTransport()
+
+	storage := createStorage()
+	// closed last, after the final save, to sync and release the bolt file
+	defer storage.Close()
+	viewHandler := view.MakeTrackingHandlerWithStorage(storage, 500)
+	if err := viewHandler.LoadError(); err != nil {
+		log.Fatalf("Failed to load tracking data: %v", err)
 	}
+	popularityHandler := view.NewSortOverrideStorage(transport)
 
-	viewHandler := view.MakeMemoryTrackingHandler("data/tracking.json", 500)
-	popularityHandler := view.NewSortOverrideStorage(conn)
+	eventLog, err := eventlog.Open("data/wal", 64<<20)
+	if err != nil {
+		log.Fatalf("Failed to open event log: %v", err)
+	}
+	defer eventLog.Close()
+	trackingHandler := events.NewLoggedTrackingHandler(eventLog, viewHandler)
+	if err = trackingHandler.Replay(); err != nil {
+		log.Printf("Failed to replay event log: %v", err)
+	}
+	viewHandler.ConnectSaveHandler(trackingHandler.Compact)
 
 	defer viewHandler.Save()
-	go func() {
-		err := events.ConnectTrackingHandler(ch, viewHandler)
-		if err != nil {
-			log.Printf("Failed to connect tracking handler: %v", err)
-		}
-	}()
+	err = events.ConnectTrackingHandler(transport, trackingHandler)
+	if err != nil {
+		log.Printf("Failed to connect tracking handler: %v", err)
+	}
+	transport.Start()
 
 	viewHandler.ConnectPopularityListener(popularityHandler)
 	mux := http.NewServeMux()
@@ -64,7 +113,7 @@ func run_application() int {
 	})
 	mux.HandleFunc("/tracking/variation/{id}", JsonHandler(func(w http.ResponseWriter, r *http.Request) (interface{}, error) {
 		id := r.PathValue("id")
-		sessionId := HandleSessionCookie(viewHandler, w, r)
+		sessionId := HandleSessionCookie(trackingHandler, w, r)
 		session := viewHandler.GetSession(sessionId)
 		if session == nil {
 			return nil, nil
@@ -73,7 +122,7 @@ func run_application() int {
 	}))
 
 	mux.HandleFunc("/tracking/my/groups", JsonHandler(func(w http.ResponseWriter, r *http.Request) (interface{}, error) {
-		sessionId := HandleSessionCookie(viewHandler, w, r)
+		sessionId := HandleSessionCookie(trackingHandler, w, r)
 		session := viewHandler.GetSession(sessionId)
 		if session == nil {
 			return nil, nil
@@ -99,7 +148,7 @@ func run_application() int {
 		return session.Groups, nil
 	}))
 	mux.HandleFunc("/tracking/my/session", JsonHandler(func(w http.ResponseWriter, r *http.Request) (interface{}, error) {
-		sessionId := HandleSessionCookie(viewHandler, w, r)
+		sessionId := HandleSessionCookie(trackingHandler, w, r)
 		return viewHandler.GetSession(sessionId), nil
 	}))
 	mux.HandleFunc("/tracking/session/{id}", JsonHandler(func(w http.ResponseWriter, r *http.Request) (interface{}, error) {
@@ -111,17 +160,27 @@ func run_application() int {
 		}
 		return viewHandler.GetSession(sessionId), nil
 	}))
-	mux.HandleFunc("GET /track/click", TrackHandler(viewHandler, TrackClick))
-	mux.HandleFunc("POST /track/click", TrackHandler(viewHandler, TrackPostClick))
-	mux.HandleFunc("/track/impressions", TrackHandler(viewHandler, TrackImpression))
-	mux.HandleFunc("/track/action", TrackHandler(viewHandler, TrackAction))
-	mux.HandleFunc("/track/suggest", TrackHandler(viewHandler, TrackSuggest))
-	mux.HandleFunc("/track/cart", TrackHandler(viewHandler, TrackCart))
-	mux.HandleFunc("/track/dataset", TrackHandler(viewHandler, TrackDataSet))
-	mux.HandleFunc("/track/enter-checkout", TrackHandler(viewHandler, TrackCheckout))
+	mux.HandleFunc("GET /track/click", TrackHandler(trackingHandler, TrackClick))
+	mux.HandleFunc("POST /track/click", TrackHandler(trackingHandler, TrackPostClick))
+	mux.HandleFunc("/track/impressions", TrackHandler(trackingHandler, TrackImpression))
+	mux.HandleFunc("/track/action", TrackHandler(trackingHandler, TrackAction))
+	mux.HandleFunc("/track/suggest", TrackHandler(trackingHandler, TrackSuggest))
+	mux.HandleFunc("/track/search", TrackHandler(trackingHandler, TrackSearch))
+	mux.HandleFunc("/track/cart", TrackHandler(trackingHandler, TrackCart))
+	mux.HandleFunc("/track/dataset", TrackHandler(trackingHandler, TrackDataSet))
+	mux.HandleFunc("/track/enter-checkout", TrackHandler(trackingHandler, TrackCheckout))
+	mux.HandleFunc("/track/purchase", TrackHandler(trackingHandler, TrackPurchase))
+	mux.HandleFunc("/track/batch", TrackBatch(trackingHandler))
 	mux.HandleFunc("GET /tracking/suggest", JsonHandler(func(w http.ResponseWriter, r *http.Request) (interface{}, error) {
 		q := r.URL.Query().Get("q")
-		return viewHandler.GetSuggestions(q), nil
+		limit := 0
+		if l := r.URL.Query().Get("limit"); l != "" {
+			var err error
+			if limit, err = strconv.Atoi(l); err != nil {
+				return nil, err
+			}
+		}
+		return viewHandler.GetSuggestions(q, r.URL.Query().Get("country"), limit), nil
 	}))
 	mux.HandleFunc("GET /tracking/funnels", JsonHandler(func(w http.ResponseWriter, r *http.Request) (interface{}, error) {
 		return viewHandler.GetFunnels()
@@ -142,11 +201,77 @@ func run_application() int {
 		return viewHandler.GetItemEvents(), nil
 	}))
 	mux.HandleFunc("GET /tracking/popularity", JsonHandler(func(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+		if window := r.URL.Query().Get("window"); window != "" {
+			return viewHandler.GetWindowItemPopularity(window)
+		}
 		return viewHandler.GetItemPopularity(), nil
 	}))
+	mux.HandleFunc("GET /tracking/debiased-popularity", JsonHandler(func(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+		return viewHandler.GetDebiasedPopularity(), nil
+	}))
+	mux.HandleFunc("GET /tracking/position-bias", JsonHandler(func(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+		return viewHandler.GetPositionPropensities(), nil
+	}))
+	mux.HandleFunc("GET /tracking/trending", JsonHandler(func(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+		return viewHandler.GetTrending(), nil
+	}))
 	mux.HandleFunc("GET /tracking/field-popularity", JsonHandler(func(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+		if window := r.URL.Query().Get("window"); window != "" {
+			return viewHandler.GetWindowFieldPopularity(window)
+		}
 		return viewHandler.GetFieldPopularity(), nil
 	}))
+	mux.HandleFunc("GET /tracking/also-bought/{id}", JsonHandler(func(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+		idString := r.PathValue("id")
+		id, err := strconv.Atoi(idString)
+		if err != nil {
+			return nil, err
+		}
+		return viewHandler.GetAlsoBought(uint(id)), nil
+	}))
+	mux.HandleFunc("GET /tracking/decay-profiles", JsonHandler(func(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+		return view.GetDecayProfiles(), nil
+	}))
+	mux.HandleFunc("GET /tracking/weights", JsonHandler(func(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+		return view.GetEventWeights(), nil
+	}))
+	mux.HandleFunc("PUT /tracking/weights", JsonHandler(func(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+		// weights missing from the body keep their current value
+		weights := view.GetEventWeights()
+		err := json.NewDecoder(r.Body).Decode(&weights)
+		if err != nil {
+			return nil, err
+		}
+		err = view.SetEventWeights(weights)
+		if err != nil {
+			return nil, err
+		}
+		return view.GetEventWeights(), nil
+	}))
+	mux.HandleFunc("GET /tracking/normalization", JsonHandler(func(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+		return view.GetQueryNormalization(), nil
+	}))
+	mux.HandleFunc("PUT /tracking/normalization", JsonHandler(func(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+		// languages missing from the body keep their current rules
+		normalization := view.GetQueryNormalization()
+		err := json.NewDecoder(r.Body).Decode(&normalization)
+		if err != nil {
+			return nil, err
+		}
+		err = view.SetQueryNormalization(normalization)
+		if err != nil {
+			return nil, err
+		}
+		return view.GetQueryNormalization(), nil
+	}))
+	mux.HandleFunc("GET /tracking/items/{id}/stats", JsonHandler(func(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+		idString := r.PathValue("id")
+		id, err := strconv.Atoi(idString)
+		if err != nil {
+			return nil, err
+		}
+		return viewHandler.GetItemStats(uint(id)), nil
+	}))
 	mux.HandleFunc("GET /tracking/dataset", JsonHandler(func(w http.ResponseWriter, r *http.Request) (interface{}, error) {
 		return viewHandler.GetDataSet(), nil
 	}))
@@ -165,10 +290,84 @@ func run_application() int {
 	}))
 
 	mux.HandleFunc("GET /tracking/queries", JsonHandler(func(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+		if window := r.URL.Query().Get("window"); window != "" {
+			return viewHandler.GetWindowQueryPopularity(window)
+		}
 		return viewHandler.GetQueries(), nil
 	}))
 	mux.HandleFunc("GET /tracking/no-results", JsonHandler(func(w http.ResponseWriter, r *http.Request) (interface{}, error) {
-		return viewHandler.GetNoResultQueries(), nil
+		query := r.URL.Query()
+		request := view.NoResultsRequest{
+			Sort:      query.Get("sort"),
+			Ascending: query.Get("order") == "asc",
+		}
+		var err error
+		if page := query.Get("page"); page != "" {
+			if request.Page, err = strconv.Atoi(page); err != nil {
+				return nil, err
+			}
+		}
+		if size := query.Get("size"); size != "" {
+			if request.Size, err = strconv.Atoi(size); err != nil {
+				return nil, err
+			}
+		}
+		return viewHandler.GetNoResultQueries(request)
+	}))
+	mux.HandleFunc("GET /tracking/reformulations", JsonHandler(func(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+		limit := 0
+		if l := r.URL.Query().Get("limit"); l != "" {
+			var err error
+			if limit, err = strconv.Atoi(l); err != nil {
+				return nil, err
+			}
+		}
+		return viewHandler.GetReformulations(r.URL.Query().Get("kind"), limit)
+	}))
+	mux.HandleFunc("GET /tracking/queries/{q}/items", JsonHandler(func(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+		query := view.NormalizeQuery(r.PathValue("q"), r.URL.Query().Get("country"))
+		return viewHandler.GetQueryItems(query), nil
+	}))
+	mux.HandleFunc("GET /tracking/ltr/export", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		format := query.Get("format")
+		encode, err := view.JudgementEncoder(format)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		request := view.JudgementRequest{}
+		if request.From, err = parseTime(query.Get("from")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if request.To, err = parseTime(query.Get("to")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if minImpressions := query.Get("min_impressions"); minImpressions != "" {
+			value, err := strconv.ParseUint(minImpressions, 10, 32)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			request.MinImpressions = uint(value)
+		}
+		switch format {
+		case view.JudgementFormatJSONL:
+			w.Header().Set("Content-Type", "application/x-ndjson")
+		case view.JudgementFormatLightGBM:
+			w.Header().Set("Content-Type", "application/x-tar")
+			w.Header().Set("Content-Disposition", `attachment; filename="judgements.tar"`)
+		default:
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		}
+		if err := encode(w, viewHandler.GetJudgements(request)); err != nil {
+			log.Printf("Failed to write judgements: %v", err)
+		}
+	})
+	mux.HandleFunc("GET /tracking/junk-queries", JsonHandler(func(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+		return viewHandler.GetJunkQueries(), nil
 	}))
 	// mux.HandleFunc("/tracking/updated", JsonHandler(func(w http.ResponseWriter, r *http.Request) (interface{}, error) {
 	// 	return viewHandler.GetUpdatedItems(), nil
@@ -0,0 +1,129 @@
+package broker
+
+import (
+	"encoding/json"
+	"log"
+	"slices"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// maxDeadLetters is how many of the latest rejected messages are kept
+const maxDeadLetters = 100
+
+var deadLetters = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "slasktracking_memory_dead_letters_total",
+	Help: "The total number of messages rejected by the in process consumers",
+})
+
+type memoryMessage struct {
+	topic string
+	body  []byte
+}
+
+type DeadLetter struct {
+	Topic string `json:"topic"`
+	Body  []byte `json:"body"`
+	Error string `json:"error"`
+}
+
+// MemoryTransport delivers messages in process over a channel, used for
+// tests and local development without a broker
+type MemoryTransport struct {
+	mu       sync.RWMutex
+	handlers map[string][]MessageHandler
+	messages chan memoryMessage
+	dead     []DeadLetter
+	done     chan struct{}
+	once     sync.Once
+	pending  sync.WaitGroup
+}
+
+func NewMemoryTransport(bufferSize int) *MemoryTransport {
+	return &MemoryTransport{
+		handlers: make(map[string][]MessageHandler),
+		messages: make(chan memoryMessage, bufferSize),
+		dead:     make([]DeadLetter, 0),
+		done:     make(chan struct{}),
+	}
+}
+
+func (t *MemoryTransport) Consume(topic string, handler MessageHandler) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.handlers[topic] = append(t.handlers[topic], handler)
+	return nil
+}
+
+// Publish queues a raw message for the consumers of the topic
+func (t *MemoryTransport) Publish(topic string, body []byte) error {
+	t.pending.Add(1)
+	t.messages <- memoryMessage{topic: topic, body: body}
+	return nil
+}
+
+func (t *MemoryTransport) SendChange(topic string, key string, data any) error {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return t.Publish(topic, body)
+}
+
+func (t *MemoryTransport) deliver(msg memoryMessage) {
+	defer t.pending.Done()
+	t.mu.RLock()
+	handlers := t.handlers[msg.topic]
+	t.mu.RUnlock()
+	for _, handler := range handlers {
+		err := handler(msg.body)
+		if err == ErrRetryMessage {
+			// a retry is a second attempt, there is no broker to come back later
+			err = handler(msg.body)
+		}
+		if err != nil {
+			log.Printf("Message on %s rejected: %v", msg.topic, err)
+			deadLetters.Inc()
+			t.mu.Lock()
+			if len(t.dead) >= maxDeadLetters {
+				t.dead = slices.Delete(t.dead, 0, 1)
+			}
+			t.dead = append(t.dead, DeadLetter{Topic: msg.topic, Body: msg.body, Error: err.Error()})
+			t.mu.Unlock()
+		}
+	}
+}
+
+func (t *MemoryTransport) Start() {
+	go func() {
+		for {
+			select {
+			case msg := <-t.messages:
+				t.deliver(msg)
+			case <-t.done:
+				return
+			}
+		}
+	}()
+}
+
+// Wait blocks until all published messages have been delivered
+func (t *MemoryTransport) Wait() {
+	t.pending.Wait()
+}
+
+func (t *MemoryTransport) Close() {
+	t.once.Do(func() {
+		close(t.done)
+	})
+}
+
+// DeadLetters returns the latest rejected messages, older ones are only
+// counted
+func (t *MemoryTransport) DeadLetters() []DeadLetter {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return slices.Clone(t.dead)
+}
@@ -0,0 +1,28 @@
+package broker
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestMemoryTransportKeepsLatestDeadLetters(t *testing.T) {
+	transport := NewMemoryTransport(10)
+	transport.Consume("events", func(body []byte) error {
+		return errors.New("rejected")
+	})
+	transport.Start()
+	defer transport.Close()
+	for i := range maxDeadLetters + 5 {
+		transport.Publish("events", []byte(fmt.Sprintf("%d", i)))
+	}
+	transport.Wait()
+
+	dead := transport.DeadLetters()
+	if len(dead) != maxDeadLetters {
+		t.Fatalf("Expected %d dead letters, got %d", maxDeadLetters, len(dead))
+	}
+	if got := string(dead[0].Body); got != "5" {
+		t.Errorf("Expected the oldest dead letters to be dropped, first is %s", got)
+	}
+}
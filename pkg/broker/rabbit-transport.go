@@ -0,0 +1,329 @@
+package broker
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/matst80/slask-finder/pkg/messaging"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	minBackoff = time.Second
+	maxBackoff = time.Minute
+	maxPending = 200
+)
+
+var (
+	reconnects = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "slasktracking_rabbit_reconnects_total",
+		Help: "The total number of RabbitMQ reconnects",
+	})
+	droppedChanges = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "slasktracking_dropped_changes_total",
+		Help: "The total number of changes dropped while disconnected",
+	})
+)
+
+type consumer func(ch *amqp.Channel) error
+
+type QueueConfig struct {
+	// Queue is the name of a durable queue bound to the topic, an exclusive
+	// server named queue is used when empty
+	Queue string
+	// DeadLetterExchange receives messages that can not be processed, they are
+	// kept in a queue with the same name so they can be replayed
+	DeadLetterExchange string
+	Prefetch           int
+}
+
+type pendingChange struct {
+	topic string
+	key   string
+	data  any
+}
+
+// RabbitTransport keeps a RabbitMQ connection alive, it declares the topics
+// and restarts the consumers after every reconnect and buffers published
+// changes while the broker is unavailable
+type RabbitTransport struct {
+	url       string
+	topics    []string
+	queues    map[string]QueueConfig
+	mu        sync.RWMutex
+	conn      *amqp.Connection
+	consumers map[string]consumer
+	pending   map[string]pendingChange
+	order     []string
+}
+
+func NewRabbitTransport(url string, topics ...string) *RabbitTransport {
+	return &RabbitTransport{
+		url:       url,
+		topics:    topics,
+		queues:    make(map[string]QueueConfig),
+		consumers: make(map[string]consumer),
+		pending:   make(map[string]pendingChange),
+		order:     make([]string, 0),
+	}
+}
+
+func nextBackoff(current time.Duration) time.Duration {
+	return min(current*2, maxBackoff)
+}
+
+// ConfigureQueue sets the queue used when consuming the topic, it has to be
+// called before Consume
+func (t *RabbitTransport) ConfigureQueue(topic string, config QueueConfig) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.queues[topic] = config
+}
+
+// Consume registers a handler that is started on its own channel every time
+// a connection is established
+func (t *RabbitTransport) Consume(topic string, handler MessageHandler) error {
+	t.mu.Lock()
+	config := t.queues[topic]
+	c := func(ch *amqp.Channel) error {
+		return consumeTopic(ch, topic, config, handler)
+	}
+	t.consumers[topic] = c
+	conn := t.conn
+	t.mu.Unlock()
+	if conn != nil {
+		go t.runConsumer(conn, topic, c)
+	}
+	return nil
+}
+
+func (t *RabbitTransport) Start() {
+	go t.run()
+}
+
+func (t *RabbitTransport) IsConnected() bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.conn != nil && !t.conn.IsClosed()
+}
+
+func (t *RabbitTransport) dial() (*amqp.Connection, error) {
+	conn, err := amqp.DialConfig(t.url, amqp.Config{
+		Properties: amqp.NewConnectionProperties(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	defer ch.Close()
+	for _, topic := range t.topics {
+		if err = messaging.DefineTopic(ch, "global", topic); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+func (t *RabbitTransport) run() {
+	backoff := minBackoff
+	for {
+		conn, err := t.dial()
+		if err != nil {
+			log.Printf("Failed to connect to RabbitMQ, retrying in %v: %v", backoff, err)
+			time.Sleep(backoff)
+			backoff = nextBackoff(backoff)
+			continue
+		}
+		backoff = minBackoff
+		closed := conn.NotifyClose(make(chan *amqp.Error, 1))
+
+		t.mu.Lock()
+		t.conn = conn
+		consumers := make(map[string]consumer, len(t.consumers))
+		for name, c := range t.consumers {
+			consumers[name] = c
+		}
+		t.mu.Unlock()
+		log.Println("Connected to RabbitMQ")
+
+		t.flushPending()
+		for name, c := range consumers {
+			go t.runConsumer(conn, name, c)
+		}
+
+		reason := <-closed
+		t.mu.Lock()
+		t.conn = nil
+		t.mu.Unlock()
+		reconnects.Inc()
+		log.Printf("RabbitMQ connection closed: %v", reason)
+	}
+}
+
+// runConsumer restarts the consumer as long as the connection is open
+func (t *RabbitTransport) runConsumer(conn *amqp.Connection, name string, c consumer) {
+	backoff := minBackoff
+	for !conn.IsClosed() {
+		ch, err := conn.Channel()
+		if err == nil {
+			err = c(ch)
+			ch.Close()
+		}
+		if conn.IsClosed() {
+			break
+		}
+		log.Printf("Consumer %s stopped, restarting in %v: %v", name, backoff, err)
+		time.Sleep(backoff)
+		backoff = nextBackoff(backoff)
+	}
+}
+
+func (t *RabbitTransport) buffer(change pendingChange) {
+	key := change.topic + "/" + change.key
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, found := t.pending[key]; !found {
+		if len(t.order) >= maxPending {
+			delete(t.pending, t.order[0])
+			t.order = t.order[1:]
+			droppedChanges.Inc()
+		}
+		t.order = append(t.order, key)
+	}
+	// only the latest change for a key is of interest
+	t.pending[key] = change
+}
+
+func (t *RabbitTransport) flushPending() {
+	t.mu.Lock()
+	order := t.order
+	pending := t.pending
+	t.order = make([]string, 0)
+	t.pending = make(map[string]pendingChange)
+	t.mu.Unlock()
+
+	for _, key := range order {
+		change := pending[key]
+		if err := t.SendChange(change.topic, change.key, change.data); err != nil {
+			log.Printf("Failed to send buffered change %s: %v", key, err)
+		}
+	}
+}
+
+// SendChange publishes the change on the topic, if the broker is unavailable
+// the latest change for each key is kept and sent after reconnecting
+func (t *RabbitTransport) SendChange(topic string, key string, data any) error {
+	t.mu.RLock()
+	conn := t.conn
+	t.mu.RUnlock()
+	change := pendingChange{topic: topic, key: key, data: data}
+	if conn == nil || conn.IsClosed() {
+		t.buffer(change)
+		return nil
+	}
+	err := messaging.SendChange(conn, "global", topic, data)
+	if err != nil {
+		log.Printf("Failed to send change %s on %s, buffering: %v", key, topic, err)
+		t.buffer(change)
+	}
+	return nil
+}
+
+func declareQueue(ch *amqp.Channel, topic string, config QueueConfig) (string, error) {
+	args := amqp.Table{}
+	if config.DeadLetterExchange != "" {
+		err := ch.ExchangeDeclare(config.DeadLetterExchange, "fanout", true, false, false, false, nil)
+		if err != nil {
+			return "", err
+		}
+		dead, err := ch.QueueDeclare(config.DeadLetterExchange, true, false, false, false, nil)
+		if err != nil {
+			return "", err
+		}
+		err = ch.QueueBind(dead.Name, "", config.DeadLetterExchange, false, nil)
+		if err != nil {
+			return "", err
+		}
+		args["x-dead-letter-exchange"] = config.DeadLetterExchange
+	}
+	durable := config.Queue != ""
+	q, err := ch.QueueDeclare(
+		config.Queue,
+		durable,  // durable
+		false,    // delete when unused
+		!durable, // exclusive
+		false,    // no-wait
+		args,
+	)
+	if err != nil {
+		return "", err
+	}
+	err = ch.QueueBind(q.Name, "#", topic, false, nil)
+	if err != nil {
+		return "", err
+	}
+	return q.Name, nil
+}
+
+// handleDelivery acks or nacks the message, without a dead letter exchange a
+// rejected message is gone so its body is logged
+func handleDelivery(msg amqp.Delivery, handler MessageHandler, deadLetter bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Panic while handling message: %v", r)
+			// requeue once, a second failure goes to the dead letter exchange
+			if err := msg.Nack(false, !msg.Redelivered); err != nil {
+				log.Printf("Failed to nack message: %v", err)
+			}
+		}
+	}()
+	err := handler(msg.Body)
+	if err == nil {
+		err = msg.Ack(false)
+	} else {
+		requeue := err == ErrRetryMessage && !msg.Redelivered
+		if !requeue && !deadLetter {
+			log.Printf("Dropping message from %s: %v, body: %s", msg.Exchange, err, msg.Body)
+		}
+		err = msg.Nack(false, requeue)
+	}
+	if err != nil {
+		log.Printf("Failed to acknowledge message: %v", err)
+	}
+}
+
+func consumeTopic(ch *amqp.Channel, topic string, config QueueConfig, handler MessageHandler) error {
+	if config.Prefetch > 0 {
+		if err := ch.Qos(config.Prefetch, 0, false); err != nil {
+			return err
+		}
+	}
+	queue, err := declareQueue(ch, topic, config)
+	if err != nil {
+		return err
+	}
+	deliveries, err := ch.Consume(
+		queue,
+		"",    // consumer
+		false, // auto-ack
+		false, // exclusive
+		false, // no-local
+		false, // no-wait
+		nil,   // args
+	)
+	if err != nil {
+		return err
+	}
+	for msg := range deliveries {
+		handleDelivery(msg, handler, config.DeadLetterExchange != "")
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package broker
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRabbitSendChangeBuffersWhileDisconnected(t *testing.T) {
+	transport := NewRabbitTransport("amqp://localhost")
+
+	transport.SendChange("sort_override", "popular", 1)
+	transport.SendChange("sort_override", "popular", 2)
+	transport.SendChange("field_sort_override", "popular-fields", 3)
+
+	if len(transport.order) != 2 {
+		t.Fatalf("Expected 2 buffered changes, got %d", len(transport.order))
+	}
+	if transport.pending["sort_override/popular"].data != 2 {
+		t.Errorf("Expected latest change to be kept, got %v", transport.pending["sort_override/popular"].data)
+	}
+}
+
+func TestRabbitSendChangeDropsOldestWhenFull(t *testing.T) {
+	transport := NewRabbitTransport("amqp://localhost")
+	for i := range maxPending + 1 {
+		transport.SendChange("sort_override", fmt.Sprintf("key-%d", i), i)
+	}
+	if len(transport.order) != maxPending {
+		t.Errorf("Expected %d buffered changes, got %d", maxPending, len(transport.order))
+	}
+	if len(transport.pending) != maxPending {
+		t.Errorf("Expected %d pending changes, got %d", maxPending, len(transport.pending))
+	}
+}
@@ -0,0 +1,19 @@
+package broker
+
+import "errors"
+
+// ErrRetryMessage can be returned from a MessageHandler when processing
+// failed for a reason that might go away, the message is delivered again
+var ErrRetryMessage = errors.New("retry message")
+
+// MessageHandler processes a single message from a topic, any error other
+// than ErrRetryMessage rejects the message for good
+type MessageHandler func(body []byte) error
+
+// Transport consumes messages from topics and publishes changes, the
+// implementations are expected to survive the broker being unavailable
+type Transport interface {
+	Consume(topic string, handler MessageHandler) error
+	SendChange(topic string, key string, data any) error
+	Start()
+}
@@ -0,0 +1,353 @@
+package eventlog
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const segmentExtension = ".log"
+
+// syncInterval is how often appended records are synced to disk, a host crash
+// loses at most the records appended in the last interval
+const syncInterval = 200 * time.Millisecond
+
+type Record struct {
+	Sequence uint64          `json:"seq"`
+	Data     json.RawMessage `json:"data"`
+}
+
+type segment struct {
+	start uint64
+	path  string
+}
+
+// Log is an append only log split in segments, each segment is named after
+// the sequence number of its first record. Appends are flushed to the os right
+// away and synced to disk every syncInterval
+type Log struct {
+	dir            string
+	maxSegmentSize int64
+	mu             sync.Mutex
+	segments       []segment
+	file           *os.File
+	writer         *bufio.Writer
+	size           int64
+	sequence       uint64
+	dirty          bool
+	done           chan struct{}
+}
+
+func segmentName(start uint64) string {
+	return fmt.Sprintf("%020d%s", start, segmentExtension)
+}
+
+func listSegments(dir string) ([]segment, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	segments := make([]segment, 0)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentExtension) {
+			continue
+		}
+		start, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExtension), 10, 64)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, segment{start: start, path: filepath.Join(dir, name)})
+	}
+	slices.SortFunc(segments, func(a, b segment) int {
+		if a.start < b.start {
+			return -1
+		}
+		if a.start > b.start {
+			return 1
+		}
+		return 0
+	})
+	return segments, nil
+}
+
+// readSegment calls fn for every complete record, a torn record at the end of
+// the segment is reported as the valid length of the file
+func readSegment(path string, fn func(record Record) error) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+	var valid int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(bytes.TrimSpace(line)) > 0 {
+				log.Printf("Ignoring incomplete record at end of %s", path)
+			}
+			return valid, nil
+		}
+		if err != nil {
+			return valid, err
+		}
+		var record Record
+		if err := json.Unmarshal(line, &record); err != nil {
+			log.Printf("Ignoring corrupt record in %s: %v", path, err)
+			return valid, nil
+		}
+		valid += int64(len(line))
+		if err := fn(record); err != nil {
+			return valid, err
+		}
+	}
+}
+
+func Open(dir string, maxSegmentSize int64) (*Log, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	segments, err := listSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+	l := &Log{
+		dir:            dir,
+		maxSegmentSize: maxSegmentSize,
+		segments:       segments,
+	}
+	if len(segments) > 0 {
+		last := segments[len(segments)-1]
+		l.sequence = last.start - 1
+		valid, err := readSegment(last.path, func(record Record) error {
+			l.sequence = record.Sequence
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		// drop a torn write from a crash so the segment stays readable
+		if err := os.Truncate(last.path, valid); err != nil {
+			return nil, err
+		}
+	}
+	if err := l.openSegment(); err != nil {
+		return nil, err
+	}
+	l.done = make(chan struct{})
+	go l.syncLoop()
+	return l, nil
+}
+
+func (l *Log) syncLoop() {
+	ticker := time.NewTicker(syncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-ticker.C:
+			if err := l.sync(); err != nil {
+				log.Printf("Failed to sync the event log: %v", err)
+			}
+		}
+	}
+}
+
+// sync writes the records appended since the last sync to disk
+func (l *Log) sync() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.dirty || l.file == nil {
+		return nil
+	}
+	if err := l.file.Sync(); err != nil {
+		return err
+	}
+	l.dirty = false
+	return nil
+}
+
+func (l *Log) openSegment() error {
+	start := l.sequence + 1
+	path := filepath.Join(l.dir, segmentName(start))
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	if len(l.segments) == 0 || l.segments[len(l.segments)-1].start != start {
+		l.segments = append(l.segments, segment{start: start, path: path})
+	}
+	l.file = file
+	l.writer = bufio.NewWriter(file)
+	l.size = stat.Size()
+	return nil
+}
+
+func (l *Log) closeSegment() error {
+	if l.file == nil {
+		return nil
+	}
+	if err := l.writer.Flush(); err != nil {
+		return err
+	}
+	if err := l.file.Sync(); err != nil {
+		return err
+	}
+	l.dirty = false
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// Append writes the data as the next record and returns its sequence number
+func (l *Log) Append(data []byte) (uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return 0, os.ErrClosed
+	}
+	line, err := json.Marshal(Record{Sequence: l.sequence + 1, Data: data})
+	if err != nil {
+		return 0, err
+	}
+	line = append(line, '\n')
+	if _, err := l.writer.Write(line); err != nil {
+		return 0, err
+	}
+	// hand the record to the os so a crashed process does not lose it, the
+	// sync loop makes it survive a crashed host
+	if err := l.writer.Flush(); err != nil {
+		return 0, err
+	}
+	l.dirty = true
+	l.sequence++
+	l.size += int64(len(line))
+	if l.maxSegmentSize > 0 && l.size >= l.maxSegmentSize {
+		if err := l.rotate(); err != nil {
+			return l.sequence, err
+		}
+	}
+	return l.sequence, nil
+}
+
+func (l *Log) rotate() error {
+	if err := l.closeSegment(); err != nil {
+		return err
+	}
+	return l.openSegment()
+}
+
+// Rotate starts a new segment if the current one has records and returns the
+// sequence number of the last written record
+func (l *Log) Rotate() (uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.size == 0 {
+		return l.sequence, nil
+	}
+	return l.sequence, l.rotate()
+}
+
+// Advance continues the numbering after the sequence number when the log is
+// behind it, as when the directory was lost while a snapshot still points
+// further ahead
+func (l *Log) Advance(sequence uint64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if sequence <= l.sequence {
+		return nil
+	}
+	if err := l.closeSegment(); err != nil {
+		return err
+	}
+	// an empty segment would be named after the wrong start
+	if l.size == 0 && len(l.segments) > 0 {
+		last := l.segments[len(l.segments)-1]
+		if err := os.Remove(last.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		l.segments = l.segments[:len(l.segments)-1]
+	}
+	l.sequence = sequence
+	return l.openSegment()
+}
+
+func (l *Log) Sequence() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.sequence
+}
+
+// Replay calls fn for every record with a sequence number after the given one
+func (l *Log) Replay(after uint64, fn func(record Record) error) error {
+	l.mu.Lock()
+	if l.writer != nil {
+		if err := l.writer.Flush(); err != nil {
+			l.mu.Unlock()
+			return err
+		}
+	}
+	segments := slices.Clone(l.segments)
+	l.mu.Unlock()
+	for i, seg := range segments {
+		if i+1 < len(segments) && segments[i+1].start <= after+1 {
+			continue
+		}
+		_, err := readSegment(seg.path, func(record Record) error {
+			if record.Sequence <= after {
+				return nil
+			}
+			return fn(record)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Truncate removes the segments that only contain records up to the sequence
+// number, the active segment is never removed
+func (l *Log) Truncate(upTo uint64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	keep := make([]segment, 0, len(l.segments))
+	for i, seg := range l.segments {
+		if i+1 < len(l.segments) && l.segments[i+1].start <= upTo+1 {
+			if err := os.Remove(seg.path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			continue
+		}
+		keep = append(keep, seg)
+	}
+	l.segments = keep
+	return nil
+}
+
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.done != nil {
+		close(l.done)
+		l.done = nil
+	}
+	return l.closeSegment()
+}
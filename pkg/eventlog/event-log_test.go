@@ -0,0 +1,136 @@
+package eventlog
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func collect(t *testing.T, l *Log, after uint64) []uint64 {
+	sequences := make([]uint64, 0)
+	err := l.Replay(after, func(record Record) error {
+		sequences = append(sequences, record.Sequence)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected replay error %v", err)
+	}
+	return sequences
+}
+
+func TestLogAppendRotateAndTruncate(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(dir, 0)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	for range 3 {
+		if _, err := l.Append([]byte(`{"event":2}`)); err != nil {
+			t.Fatalf("unexpected append error %v", err)
+		}
+	}
+	sequence, err := l.Rotate()
+	if err != nil || sequence != 3 {
+		t.Fatalf("Expected rotate at 3, got %d %v", sequence, err)
+	}
+	l.Append([]byte(`{"event":5}`))
+	l.Append([]byte(`{"event":5}`))
+
+	if got := collect(t, l, 3); len(got) != 2 || got[0] != 4 {
+		t.Errorf("Expected records 4 and 5, got %v", got)
+	}
+	if err := l.Truncate(sequence); err != nil {
+		t.Fatalf("unexpected truncate error %v", err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("Expected 1 segment after truncate, got %d", len(entries))
+	}
+	l.Close()
+
+	reopened, err := Open(dir, 0)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer reopened.Close()
+	if reopened.Sequence() != 5 {
+		t.Errorf("Expected sequence 5 after reopen, got %d", reopened.Sequence())
+	}
+	if got := collect(t, reopened, 0); len(got) != 2 {
+		t.Errorf("Expected 2 records after reopen, got %v", got)
+	}
+}
+
+func TestLogIgnoresTornRecord(t *testing.T) {
+	dir := t.TempDir()
+	l, _ := Open(dir, 0)
+	l.Append([]byte(`{"event":2}`))
+	l.Close()
+
+	file, _ := os.OpenFile(dir+"/"+segmentName(1), os.O_APPEND|os.O_WRONLY, 0644)
+	file.WriteString(`{"seq":2,"data":{"ev`)
+	file.Close()
+
+	reopened, err := Open(dir, 0)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer reopened.Close()
+	if reopened.Sequence() != 1 {
+		t.Errorf("Expected sequence 1, got %d", reopened.Sequence())
+	}
+	sequence, _ := reopened.Append([]byte(`{"event":2}`))
+	if sequence != 2 {
+		t.Errorf("Expected next sequence 2, got %d", sequence)
+	}
+	if got := collect(t, reopened, 0); len(got) != 2 {
+		t.Errorf("Expected 2 records, got %v", got)
+	}
+}
+
+func TestLogAdvance(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(dir, 0)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := l.Advance(10); err != nil {
+		t.Fatalf("unexpected advance error %v", err)
+	}
+	if sequence, _ := l.Append([]byte(`{"event":2}`)); sequence != 11 {
+		t.Errorf("Expected numbering to continue at 11, got %d", sequence)
+	}
+	if err := l.Advance(5); err != nil || l.Sequence() != 11 {
+		t.Errorf("Expected advance to an older sequence to do nothing, got %d %v", l.Sequence(), err)
+	}
+	l.Close()
+
+	l, err = Open(dir, 0)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer l.Close()
+	if got := collect(t, l, 0); len(got) != 1 || got[0] != 11 {
+		t.Errorf("Expected only record 11 after reopening, got %v", got)
+	}
+}
+
+func TestLogSyncsAppends(t *testing.T) {
+	l, err := Open(t.TempDir(), 0)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer l.Close()
+	l.Append([]byte(`{"event":2}`))
+	deadline := time.Now().Add(10 * syncInterval)
+	for time.Now().Before(deadline) {
+		l.mu.Lock()
+		dirty := l.dirty
+		l.mu.Unlock()
+		if !dirty {
+			return
+		}
+		time.Sleep(syncInterval / 4)
+	}
+	t.Errorf("Expected the appended record to be synced within %v", syncInterval)
+}
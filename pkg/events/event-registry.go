@@ -0,0 +1,240 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/matst80/slask-finder/pkg/types"
+	"github.com/matst80/slask-tracking/pkg/view"
+)
+
+var (
+	ErrInvalidMessage   = errors.New("invalid tracking message")
+	ErrUnknownEventType = errors.New("unknown event type")
+	ErrMissingEventData = errors.New("missing event data")
+	ErrMissingItem      = errors.New("missing item id")
+	ErrMissingItems     = errors.New("no items in event")
+)
+
+type EventRegistration struct {
+	Name   string
+	Decode func(data []byte) (view.TrackingEvent, error)
+	Handle func(handler view.TrackingHandler, event view.TrackingEvent, r *http.Request) error
+}
+
+func decodeAs[T any, PT interface {
+	*T
+	view.TrackingEvent
+}](data []byte) (PT, error) {
+	var event T
+	if err := json.Unmarshal(data, &event); err != nil {
+		return nil, err
+	}
+	result := PT(&event)
+	if result.GetBaseEvent() == nil {
+		return nil, ErrMissingEventData
+	}
+	return result, nil
+}
+
+func requireItem(item *view.BaseItem) error {
+	if item == nil || item.Id == 0 {
+		return ErrMissingItem
+	}
+	return nil
+}
+
+func decodeCart(data []byte) (view.TrackingEvent, error) {
+	event, err := decodeAs[view.CartEvent](data)
+	if err != nil {
+		return nil, err
+	}
+	return event, requireItem(event.BaseItem)
+}
+
+func handleCart(handler view.TrackingHandler, event view.TrackingEvent, r *http.Request) error {
+	handler.HandleCartEvent(*event.(*view.CartEvent), r)
+	return nil
+}
+
+var Registry = map[uint16]EventRegistration{
+	view.EVENT_SESSION_START: {
+		Name: "session",
+		Decode: func(data []byte) (view.TrackingEvent, error) {
+			return decodeAs[view.Session](data)
+		},
+		Handle: func(handler view.TrackingHandler, event view.TrackingEvent, r *http.Request) error {
+			handler.HandleSessionEvent(*event.(*view.Session))
+			return nil
+		},
+	},
+	view.EVENT_SEARCH: {
+		Name: "search",
+		Decode: func(data []byte) (view.TrackingEvent, error) {
+			event, err := decodeAs[view.SearchEvent](data)
+			if err != nil {
+				return nil, err
+			}
+			if event.Filters == nil {
+				event.Filters = &types.Filters{}
+			}
+			return event, nil
+		},
+		Handle: func(handler view.TrackingHandler, event view.TrackingEvent, r *http.Request) error {
+			handler.HandleSearchEvent(*event.(*view.SearchEvent), r)
+			return nil
+		},
+	},
+	view.EVENT_ITEM_CLICK: {
+		Name: "click",
+		Decode: func(data []byte) (view.TrackingEvent, error) {
+			event, err := decodeAs[view.Event](data)
+			if err != nil {
+				return nil, err
+			}
+			return event, requireItem(event.BaseItem)
+		},
+		Handle: func(handler view.TrackingHandler, event view.TrackingEvent, r *http.Request) error {
+			handler.HandleEvent(*event.(*view.Event), r)
+			return nil
+		},
+	},
+	view.CART_LEGACY_ADD:    {Name: "cart", Decode: decodeCart, Handle: handleCart},
+	view.CART_LEGACY_REMOVE: {Name: "cart", Decode: decodeCart, Handle: handleCart},
+	view.EVENT_ITEM_IMPRESS: {
+		Name: "impression",
+		Decode: func(data []byte) (view.TrackingEvent, error) {
+			event, err := decodeAs[view.ImpressionEvent](data)
+			if err != nil {
+				return nil, err
+			}
+			if len(event.Items) == 0 {
+				return event, ErrMissingItems
+			}
+			return event, nil
+		},
+		Handle: func(handler view.TrackingHandler, event view.TrackingEvent, r *http.Request) error {
+			handler.HandleImpressionEvent(*event.(*view.ImpressionEvent), r)
+			return nil
+		},
+	},
+	view.EVENT_ITEM_ACTION: {
+		Name: "action",
+		Decode: func(data []byte) (view.TrackingEvent, error) {
+			event, err := decodeAs[view.ActionEvent](data)
+			if err != nil {
+				return nil, err
+			}
+			if event.Action == "" {
+				return event, errors.New("missing action")
+			}
+			return event, nil
+		},
+		Handle: func(handler view.TrackingHandler, event view.TrackingEvent, r *http.Request) error {
+			handler.HandleActionEvent(*event.(*view.ActionEvent), r)
+			return nil
+		},
+	},
+	view.EVENT_SUGGEST: {
+		Name: "suggest",
+		Decode: func(data []byte) (view.TrackingEvent, error) {
+			return decodeAs[view.SuggestEvent](data)
+		},
+		Handle: func(handler view.TrackingHandler, event view.TrackingEvent, r *http.Request) error {
+			handler.HandleSuggestEvent(*event.(*view.SuggestEvent), r)
+			return nil
+		},
+	},
+	view.EVENT_DATA_SET: {
+		Name: "dataset",
+		Decode: func(data []byte) (view.TrackingEvent, error) {
+			event, err := decodeAs[view.DataSetEvent](data)
+			if err != nil {
+				return nil, err
+			}
+			if event.Query == "" {
+				return event, errors.New("missing query")
+			}
+			return event, nil
+		},
+		Handle: func(handler view.TrackingHandler, event view.TrackingEvent, r *http.Request) error {
+			handler.HandleDataSetEvent(*event.(*view.DataSetEvent), r)
+			return nil
+		},
+	},
+	view.CART_ADD:      {Name: "cart_add", Decode: decodeCart, Handle: handleCart},
+	view.CART_REMOVE:   {Name: "cart_remove", Decode: decodeCart, Handle: handleCart},
+	view.CART_QUANTITY: {Name: "cart_quantity", Decode: decodeCart, Handle: handleCart},
+	view.CART_CLEAR: {
+		Name: "cart_clear",
+		Decode: func(data []byte) (view.TrackingEvent, error) {
+			return decodeAs[view.CartEvent](data)
+		},
+		Handle: handleCart,
+	},
+	view.CART_ENTER_CHECKOUT: {
+		Name: "checkout",
+		Decode: func(data []byte) (view.TrackingEvent, error) {
+			event, err := decodeAs[view.EnterCheckoutEvent](data)
+			if err != nil {
+				return nil, err
+			}
+			if len(event.Items) == 0 {
+				return event, ErrMissingItems
+			}
+			return event, nil
+		},
+		Handle: func(handler view.TrackingHandler, event view.TrackingEvent, r *http.Request) error {
+			handler.HandleEnterCheckout(*event.(*view.EnterCheckoutEvent), r)
+			return nil
+		},
+	},
+	view.CART_PURCHASE: {
+		Name: "purchase",
+		Decode: func(data []byte) (view.TrackingEvent, error) {
+			event, err := decodeAs[view.PurchaseEvent](data)
+			if err != nil {
+				return nil, err
+			}
+			if event.OrderId == "" {
+				return event, errors.New("missing order id")
+			}
+			if len(event.Items) == 0 {
+				return event, ErrMissingItems
+			}
+			return event, nil
+		},
+		Handle: func(handler view.TrackingHandler, event view.TrackingEvent, r *http.Request) error {
+			return handler.HandlePurchaseEvent(*event.(*view.PurchaseEvent), r)
+		},
+	},
+}
+
+// DecodeEvent reads the event code from the message and decodes it with the
+// registered decoder, the event code is returned even if decoding fails
+func DecodeEvent(data []byte) (uint16, view.TrackingEvent, error) {
+	var base view.BaseEvent
+	if err := json.Unmarshal(data, &base); err != nil {
+		return 0, nil, fmt.Errorf("%w: %v", ErrInvalidMessage, err)
+	}
+	registration, ok := Registry[base.Event]
+	if !ok {
+		return base.Event, nil, fmt.Errorf("%w %d", ErrUnknownEventType, base.Event)
+	}
+	event, err := registration.Decode(data)
+	if err != nil {
+		return base.Event, nil, fmt.Errorf("%s: %w", registration.Name, err)
+	}
+	return base.Event, event, nil
+}
+
+// HandleEvent passes a decoded event to the matching TrackingHandler method
+func HandleEvent(handler view.TrackingHandler, event view.TrackingEvent, r *http.Request) error {
+	registration, ok := Registry[event.GetType()]
+	if !ok {
+		return fmt.Errorf("%w %d", ErrUnknownEventType, event.GetType())
+	}
+	return registration.Handle(handler, event, r)
+}
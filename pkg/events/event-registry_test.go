@@ -0,0 +1,58 @@
+package events
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/matst80/slask-tracking/pkg/view"
+)
+
+func TestRegistryCoversEventTypes(t *testing.T) {
+	eventTypes := []uint16{
+		view.EVENT_SESSION_START,
+		view.EVENT_SEARCH,
+		view.EVENT_ITEM_CLICK,
+		view.EVENT_ITEM_IMPRESS,
+		view.EVENT_ITEM_ACTION,
+		view.EVENT_SUGGEST,
+		view.EVENT_DATA_SET,
+		view.CART_ADD,
+		view.CART_REMOVE,
+		view.CART_CLEAR,
+		view.CART_ENTER_CHECKOUT,
+		view.CART_QUANTITY,
+		view.CART_PURCHASE,
+	}
+	for _, eventType := range eventTypes {
+		if _, ok := Registry[eventType]; !ok {
+			t.Errorf("Missing registration for event type %d", eventType)
+		}
+	}
+}
+
+func TestDecodeEvent(t *testing.T) {
+	eventType, event, err := DecodeEvent([]byte(`{"event":11,"id":123,"quantity":2}`))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if eventType != view.CART_ADD {
+		t.Errorf("Expected event type %d, got %d", view.CART_ADD, eventType)
+	}
+	cart, ok := event.(*view.CartEvent)
+	if !ok {
+		t.Fatalf("Expected cart event, got %T", event)
+	}
+	if cart.Id != 123 || cart.Quantity != 2 {
+		t.Errorf("Unexpected cart event %+v", cart.BaseItem)
+	}
+
+	_, _, err = DecodeEvent([]byte(`{"event":2}`))
+	if !errors.Is(err, ErrMissingItem) {
+		t.Errorf("Expected missing item error, got %v", err)
+	}
+
+	_, _, err = DecodeEvent([]byte(`{"event":99}`))
+	if !errors.Is(err, ErrUnknownEventType) {
+		t.Errorf("Expected unknown event error, got %v", err)
+	}
+}
@@ -0,0 +1,205 @@
+package events
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/matst80/slask-tracking/pkg/eventlog"
+	"github.com/matst80/slask-tracking/pkg/view"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var logFailures = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "slasktracking_event_log_failures_total",
+	Help: "The total number of events that could not be written to the event log",
+})
+
+type SnapshotHandler interface {
+	view.TrackingHandler
+	GetLogSequence() uint64
+	PrepareSnapshot(sequence uint64) (func() error, error)
+	SetReplaying(replaying bool)
+}
+
+// LoggedTrackingHandler writes every event to the event log before it is
+// applied to the wrapped handler, a purchase is written after it is applied so
+// a rejected purchase is not replayed. Snapshots are cut in step with the log
+type LoggedTrackingHandler struct {
+	mu        sync.Mutex
+	compactMu sync.Mutex
+	log       *eventlog.Log
+	handler   SnapshotHandler
+}
+
+func NewLoggedTrackingHandler(eventLog *eventlog.Log, handler SnapshotHandler) *LoggedTrackingHandler {
+	return &LoggedTrackingHandler{
+		log:     eventLog,
+		handler: handler,
+	}
+}
+
+// Replay applies the records written after the last snapshot, a log that
+// ends before the snapshot was recreated so all of it is newer
+func (h *LoggedTrackingHandler) Replay() error {
+	recreated, err := h.replay()
+	if err != nil || !recreated {
+		return err
+	}
+	// the replayed records would be skipped after the next restart without a
+	// snapshot that covers them
+	return h.Compact()
+}
+
+func (h *LoggedTrackingHandler) replay() (bool, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	after := h.handler.GetLogSequence()
+	recreated := h.log.Sequence() < after
+	if recreated {
+		log.Printf("Event log ends at %d before the snapshot at %d, replaying the whole log", h.log.Sequence(), after)
+		after = 0
+	}
+	replayed := 0
+	// the events are counted at the time they happened, not at startup
+	h.handler.SetReplaying(true)
+	defer h.handler.SetReplaying(false)
+	err := h.log.Replay(after, func(record eventlog.Record) error {
+		_, event, err := DecodeEvent(record.Data)
+		if err != nil {
+			log.Printf("Skipping event log record %d: %v", record.Sequence, err)
+			return nil
+		}
+		if err := HandleEvent(h.handler, event, nil); err != nil {
+			log.Printf("Failed to replay event log record %d: %v", record.Sequence, err)
+		}
+		replayed++
+		return nil
+	})
+	log.Printf("Replayed %d events from the event log after %d", replayed, after)
+	if err != nil || !recreated {
+		return false, err
+	}
+	// continue numbering after the snapshot
+	return true, h.log.Advance(h.handler.GetLogSequence())
+}
+
+// Compact cuts a new snapshot at the current log position and removes the
+// segments that are covered by it. Events are only held back while the state
+// is captured, the snapshot is written and the log truncated without the lock
+func (h *LoggedTrackingHandler) Compact() error {
+	// an older snapshot must not be written over a newer one
+	h.compactMu.Lock()
+	defer h.compactMu.Unlock()
+
+	h.mu.Lock()
+	sequence, err := h.log.Rotate()
+	var write func() error
+	if err == nil {
+		write, err = h.handler.PrepareSnapshot(sequence)
+	}
+	h.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if write != nil {
+		if err := write(); err != nil {
+			return err
+		}
+	}
+	return h.log.Truncate(sequence)
+}
+
+func (h *LoggedTrackingHandler) append(event view.TrackingEvent) {
+	// a replay needs the time the event happened
+	if base := event.GetBaseEvent(); base != nil {
+		base.SetTimestamp()
+	}
+	data, err := json.Marshal(event)
+	if err == nil {
+		_, err = h.log.Append(data)
+	}
+	if err != nil {
+		logFailures.Inc()
+		log.Printf("Failed to write event to the event log: %v", err)
+	}
+}
+
+func (h *LoggedTrackingHandler) HandleSessionEvent(event view.Session) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.append(&event)
+	h.handler.HandleSessionEvent(event)
+}
+
+func (h *LoggedTrackingHandler) HandleEvent(event view.Event, r *http.Request) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.append(&event)
+	h.handler.HandleEvent(event, r)
+}
+
+func (h *LoggedTrackingHandler) HandleSearchEvent(event view.SearchEvent, r *http.Request) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.append(&event)
+	h.handler.HandleSearchEvent(event, r)
+}
+
+func (h *LoggedTrackingHandler) HandleCartEvent(event view.CartEvent, r *http.Request) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.append(&event)
+	h.handler.HandleCartEvent(event, r)
+}
+
+func (h *LoggedTrackingHandler) HandleDataSetEvent(event view.DataSetEvent, r *http.Request) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.append(&event)
+	h.handler.HandleDataSetEvent(event, r)
+}
+
+func (h *LoggedTrackingHandler) HandleEnterCheckout(event view.EnterCheckoutEvent, r *http.Request) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.append(&event)
+	h.handler.HandleEnterCheckout(event, r)
+}
+
+func (h *LoggedTrackingHandler) HandlePurchaseEvent(event view.PurchaseEvent, r *http.Request) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := h.handler.HandlePurchaseEvent(event, r); err != nil {
+		return err
+	}
+	h.append(&event)
+	return nil
+}
+
+func (h *LoggedTrackingHandler) HandleImpressionEvent(event view.ImpressionEvent, r *http.Request) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.append(&event)
+	h.handler.HandleImpressionEvent(event, r)
+}
+
+func (h *LoggedTrackingHandler) HandleActionEvent(event view.ActionEvent, r *http.Request) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.append(&event)
+	h.handler.HandleActionEvent(event, r)
+}
+
+func (h *LoggedTrackingHandler) HandleSuggestEvent(event view.SuggestEvent, r *http.Request) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.append(&event)
+	h.handler.HandleSuggestEvent(event, r)
+}
+
+func (h *LoggedTrackingHandler) GetSession(sessionId int64) *view.SessionData {
+	return h.handler.GetSession(sessionId)
+}
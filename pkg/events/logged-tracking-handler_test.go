@@ -0,0 +1,109 @@
+package events
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/matst80/slask-tracking/pkg/eventlog"
+	"github.com/matst80/slask-tracking/pkg/view"
+)
+
+func TestReplayUsesEventTime(t *testing.T) {
+	eventLog, err := eventlog.Open(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer eventLog.Close()
+	ts := time.Now().Add(-6 * time.Hour).Unix()
+	eventLog.Append([]byte(fmt.Sprintf(`{"event":2,"session_id":1,"id":42,"ts":%d}`, ts)))
+
+	handler := view.MakeMemoryTrackingHandler(filepath.Join(t.TempDir(), "tracking.json"), 500)
+	if err := NewLoggedTrackingHandler(eventLog, handler).Replay(); err != nil {
+		t.Fatalf("unexpected replay error %v", err)
+	}
+	if got := handler.GetItemEvents().Values[42].TimeStamp; got != ts {
+		t.Errorf("Expected the replayed click at %d, got %d", ts, got)
+	}
+}
+
+func TestReplayRecreatedLog(t *testing.T) {
+	handler := view.MakeMemoryTrackingHandler(filepath.Join(t.TempDir(), "tracking.json"), 500)
+	if err := handler.SaveSnapshot(10); err != nil {
+		t.Fatal(err)
+	}
+	eventLog, err := eventlog.Open(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer eventLog.Close()
+	eventLog.Append([]byte(`{"event":11,"session_id":1,"id":42,"quantity":1}`))
+
+	if err := NewLoggedTrackingHandler(eventLog, handler).Replay(); err != nil {
+		t.Fatalf("unexpected replay error %v", err)
+	}
+	if handler.GetItemEvents().Values[42].Sum == 0 {
+		t.Errorf("Expected the records of the recreated log to be replayed")
+	}
+	if sequence, _ := eventLog.Append([]byte(`{"event":2,"id":1}`)); sequence != 11 || handler.GetLogSequence() != 10 {
+		t.Errorf("Expected numbering to continue after the snapshot, got %d and snapshot %d", sequence, handler.GetLogSequence())
+	}
+}
+
+func TestDuplicatePurchaseIsNotLogged(t *testing.T) {
+	eventLog, err := eventlog.Open(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer eventLog.Close()
+	handler := NewLoggedTrackingHandler(eventLog, view.MakeMemoryTrackingHandler(filepath.Join(t.TempDir(), "tracking.json"), 500))
+	purchase := func() view.PurchaseEvent {
+		return view.PurchaseEvent{
+			BaseEvent: &view.BaseEvent{SessionId: 1, Event: view.CART_PURCHASE},
+			OrderId:   "order-1",
+			Items:     []view.BaseItem{{Id: 42, Quantity: 1}},
+		}
+	}
+	if err := handler.HandlePurchaseEvent(purchase(), nil); err != nil {
+		t.Fatalf("unexpected purchase error %v", err)
+	}
+	if err := handler.HandlePurchaseEvent(purchase(), nil); err == nil {
+		t.Fatalf("Expected the duplicate purchase to be rejected")
+	}
+	if got := eventLog.Sequence(); got != 1 {
+		t.Errorf("Expected only the applied purchase in the event log, got %d records", got)
+	}
+}
+
+func TestCompactWritesSnapshotAtLogPosition(t *testing.T) {
+	eventLog, err := eventlog.Open(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer eventLog.Close()
+	tracking := view.MakeMemoryTrackingHandler(filepath.Join(t.TempDir(), "tracking.json"), 500)
+	handler := NewLoggedTrackingHandler(eventLog, tracking)
+	handler.HandleEvent(view.Event{BaseEvent: &view.BaseEvent{SessionId: 1, Event: view.EVENT_ITEM_CLICK}, BaseItem: &view.BaseItem{Id: 42}}, nil)
+	if err := handler.Compact(); err != nil {
+		t.Fatalf("unexpected compact error %v", err)
+	}
+	if got := tracking.GetLogSequence(); got != eventLog.Sequence() {
+		t.Errorf("Expected the snapshot at %d, got %d", eventLog.Sequence(), got)
+	}
+	if got := collectSequences(t, eventLog); len(got) != 0 {
+		t.Errorf("Expected the compacted records to be removed, got %v", got)
+	}
+}
+
+func collectSequences(t *testing.T, eventLog *eventlog.Log) []uint64 {
+	sequences := make([]uint64, 0)
+	err := eventLog.Replay(0, func(record eventlog.Record) error {
+		sequences = append(sequences, record.Sequence)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected replay error %v", err)
+	}
+	return sequences
+}
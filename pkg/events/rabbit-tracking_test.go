@@ -0,0 +1,55 @@
+package events
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/matst80/slask-tracking/pkg/broker"
+	"github.com/matst80/slask-tracking/pkg/view"
+)
+
+func TestConnectTrackingHandlerWithMemoryTransport(t *testing.T) {
+	transport := broker.NewMemoryTransport(10)
+	defer transport.Close()
+	handler := view.MakeMemoryTrackingHandler(t.TempDir()+"/tracking.json", 500)
+
+	if err := ConnectTrackingHandler(transport, handler); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	transport.Start()
+
+	transport.Publish("tracking", []byte(`{"event":11,"session_id":1,"id":42,"quantity":1}`))
+	transport.Publish("tracking", []byte(`not json`))
+	transport.Wait()
+
+	if handler.GetItemEvents().Values[42].Sum == 0 {
+		t.Errorf("Expected cart event for item 42")
+	}
+	dead := transport.DeadLetters()
+	if len(dead) != 1 {
+		t.Errorf("Expected 1 dead letter, got %d", len(dead))
+	}
+}
+
+type failingHandler struct {
+	view.TrackingHandler
+	err error
+}
+
+func (h failingHandler) HandlePurchaseEvent(event view.PurchaseEvent, r *http.Request) error {
+	return h.err
+}
+
+func TestHandleTrackingMessageRetriesHandlerErrors(t *testing.T) {
+	purchase := []byte(`{"event":16,"session_id":1,"order_id":"a","items":[{"id":1,"quantity":1}]}`)
+	if err := handleTrackingMessage(failingHandler{err: errors.New("storage unavailable")}, purchase); err != broker.ErrRetryMessage {
+		t.Errorf("Expected handler errors to be retried, got %v", err)
+	}
+	if err := handleTrackingMessage(failingHandler{err: view.ErrDuplicateOrder}, purchase); err != nil {
+		t.Errorf("Expected duplicate orders to be acked, got %v", err)
+	}
+	if err := handleTrackingMessage(failingHandler{}, []byte(`{"event":16,"session_id":1}`)); err == nil || err == broker.ErrRetryMessage {
+		t.Errorf("Expected invalid events to be rejected, got %v", err)
+	}
+}
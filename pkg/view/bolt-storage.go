@@ -0,0 +1,179 @@
+package view
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"log"
+	"maps"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// sessions that have not been updated for this long are dropped from memory
+// after they have been written
+const hotSessionAge = time.Hour
+
+var (
+	stateBucket        = []byte("state")
+	sessionBucket      = []byte("sessions")
+	sessionIndexBucket = []byte("session_updates")
+	stateKey           = []byte("snapshot")
+)
+
+// BoltStorage keeps the global state as a snapshot and every session under
+// its own key, sessions are loaded on demand. Only sessions leave memory, the
+// global aggregates like item, field and query events, query items and
+// judgements stay in memory and are written as one snapshot
+type BoltStorage struct {
+	db *bolt.DB
+}
+
+func OpenBoltStorage(path string) (*BoltStorage, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{stateBucket, sessionBucket, sessionIndexBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStorage{db: db}, nil
+}
+
+func sessionKey(sessionId int64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(sessionId))
+	return key
+}
+
+func (b *BoltStorage) Load(s *PersistentMemoryTrackingHandler) error {
+	return b.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(stateBucket).Get(stateKey)
+		if data == nil {
+			return nil
+		}
+		// sessions from an older snapshot are moved to their bucket on save
+		return readSnapshot(bytes.NewReader(data), s)
+	})
+}
+
+func (b *BoltStorage) LoadSession(sessionId int64) (*SessionData, error) {
+	var session *SessionData
+	err := b.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(sessionBucket).Get(sessionKey(sessionId))
+		if data == nil {
+			return nil
+		}
+		session = &SessionData{}
+		return json.Unmarshal(data, session)
+	})
+	return session, err
+}
+
+type encodedSession struct {
+	key        []byte
+	data       []byte
+	lastUpdate int64
+}
+
+func (b *BoltStorage) Save(s *PersistentMemoryTrackingHandler) error {
+	return saveSnapshot(b, s)
+}
+
+// Snapshot encodes the state under the handler lock and writes it after the
+// lock is released so tracking is not blocked by the disk
+func (b *BoltStorage) Snapshot(s *PersistentMemoryTrackingHandler) (func() error, error) {
+	s.mu.Lock()
+	sessions := s.Sessions
+	s.Sessions = nil
+	state, err := json.Marshal(s)
+	s.Sessions = sessions
+	if err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+	encoded := make([]encodedSession, 0, len(sessions))
+	for id, session := range sessions {
+		data, err := json.Marshal(session)
+		if err != nil {
+			s.mu.Unlock()
+			return nil, err
+		}
+		encoded = append(encoded, encodedSession{key: sessionKey(id), data: data, lastUpdate: session.LastUpdate})
+	}
+	s.mu.Unlock()
+
+	return func() error {
+		return b.write(s, state, encoded)
+	}, nil
+}
+
+func (b *BoltStorage) write(s *PersistentMemoryTrackingHandler, state []byte, encoded []encodedSession) error {
+	limit := time.Now().Add(-sessionMaxAge).Unix()
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(stateBucket).Put(stateKey, state); err != nil {
+			return err
+		}
+		sessionData := tx.Bucket(sessionBucket)
+		index := tx.Bucket(sessionIndexBucket)
+		for _, session := range encoded {
+			if err := sessionData.Put(session.key, session.data); err != nil {
+				return err
+			}
+			lastUpdate := make([]byte, 8)
+			binary.BigEndian.PutUint64(lastUpdate, uint64(session.lastUpdate))
+			if err := index.Put(session.key, lastUpdate); err != nil {
+				return err
+			}
+		}
+
+		expired := make([][]byte, 0)
+		err := index.ForEach(func(key, value []byte) error {
+			if int64(binary.BigEndian.Uint64(value)) < limit {
+				expired = append(expired, bytes.Clone(key))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, key := range expired {
+			if err = index.Delete(key); err != nil {
+				return err
+			}
+			if err = sessionData.Delete(key); err != nil {
+				return err
+			}
+		}
+		if len(expired) > 0 {
+			log.Printf("Removed %d expired sessions from storage", len(expired))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// a session updated while writing is newer than the hot limit and stays
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hotLimit := time.Now().Add(-hotSessionAge).Unix()
+	maps.DeleteFunc(s.Sessions, func(key int64, value *SessionData) bool {
+		return value.LastUpdate < hotLimit
+	})
+	return nil
+}
+
+func (b *BoltStorage) Close() error {
+	return b.db.Close()
+}
@@ -0,0 +1,73 @@
+package view
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBoltStorageKeepsColdSessionsOnDisk(t *testing.T) {
+	storage, err := OpenBoltStorage(filepath.Join(t.TempDir(), "tracking.db"))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer storage.Close()
+	handler := MakeTrackingHandlerWithStorage(storage, 500)
+
+	handler.HandleEvent(Event{
+		BaseEvent: &BaseEvent{Event: EVENT_ITEM_CLICK, SessionId: 1},
+		BaseItem:  &BaseItem{Id: 10},
+	}, nil)
+	handler.HandleEvent(Event{
+		BaseEvent: &BaseEvent{Event: EVENT_ITEM_CLICK, SessionId: 2},
+		BaseItem:  &BaseItem{Id: 11},
+	}, nil)
+	handler.Sessions[1].LastUpdate = time.Now().Add(-2 * hotSessionAge).Unix()
+
+	if err := storage.Save(handler); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, ok := handler.Sessions[1]; ok {
+		t.Errorf("Expected cold session to be evicted from memory")
+	}
+	if _, ok := handler.Sessions[2]; !ok {
+		t.Errorf("Expected hot session to stay in memory")
+	}
+	session := handler.GetSession(1)
+	if session == nil || session.ItemEvents.Values[10].Sum == 0 {
+		t.Fatalf("Expected session 1 to be loaded from storage, got %+v", session)
+	}
+
+	loaded := MakeTrackingHandlerWithStorage(storage, 500)
+	if loaded.ItemEvents.Len() != 2 {
+		t.Errorf("Expected 2 item events after load, got %d", loaded.ItemEvents.Len())
+	}
+}
+
+func TestBoltStorageCloseReleasesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tracking.db")
+	storage, err := OpenBoltStorage(path)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	handler := MakeTrackingHandlerWithStorage(storage, 500)
+	handler.HandleEvent(Event{
+		BaseEvent: &BaseEvent{Event: EVENT_ITEM_CLICK, SessionId: 1},
+		BaseItem:  &BaseItem{Id: 10},
+	}, nil)
+	if err := storage.Save(handler); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := storage.Close(); err != nil {
+		t.Fatalf("unexpected close error %v", err)
+	}
+
+	reopened, err := OpenBoltStorage(path)
+	if err != nil {
+		t.Fatalf("Expected the file lock to be released, got %v", err)
+	}
+	defer reopened.Close()
+	if loaded := MakeTrackingHandlerWithStorage(reopened, 500); loaded.GetSession(1) == nil {
+		t.Errorf("Expected the session to be synced to disk")
+	}
+}
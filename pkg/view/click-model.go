@@ -0,0 +1,172 @@
+package view
+
+import (
+	"log"
+	"time"
+
+	"github.com/matst80/slask-finder/pkg/sorting"
+)
+
+const (
+	ProfileClickModel = "click-model"
+	// positions after the last bucket share its propensity
+	clickModelPositions = 100
+	minPropensity       = 0.01
+	// items start out with the attractiveness of the average item, worth this
+	// many examinations
+	attractivenessPrior = 20
+)
+
+type PositionStats struct {
+	Impressions DecayValue `json:"impressions"`
+	Clicks      DecayValue `json:"clicks"`
+}
+
+// ClickModel is a position based click model, a click needs the position to
+// be examined and the item to be attractive. The examination propensity of a
+// position is its click rate relative to the first position, an item is
+// credited with the propensity of every impression so the attractiveness is
+// clicks per expected examination
+type ClickModel struct {
+	Positions []PositionStats `json:"positions"`
+	Examined  DecayList       `json:"examined"`
+	Clicks    DecayList       `json:"clicks"`
+}
+
+type PositionPropensity struct {
+	Position    int     `json:"position"`
+	Impressions float64 `json:"impressions"`
+	Clicks      float64 `json:"clicks"`
+	Propensity  float64 `json:"propensity"`
+}
+
+func NewClickModel() *ClickModel {
+	return &ClickModel{
+		Positions: make([]PositionStats, clickModelPositions),
+		Examined:  NewDecayList(ProfileClickModel),
+		Clicks:    NewDecayList(ProfileClickModel),
+	}
+}
+
+func (m *ClickModel) bind() {
+	if len(m.Positions) < clickModelPositions {
+		m.Positions = append(m.Positions, make([]PositionStats, clickModelPositions-len(m.Positions))...)
+	}
+	m.Examined.Bind(ProfileClickModel)
+	m.Clicks.Bind(ProfileClickModel)
+}
+
+func positionBucket(position float32) int {
+	return min(max(int(position), 0), clickModelPositions-1)
+}
+
+func (m *ClickModel) clickRate(position int, profile *DecayProfile, now int64) float64 {
+	stats := m.Positions[position]
+	impressions := stats.Impressions.ValueAt(profile, now)
+	if impressions <= 0 {
+		return 0
+	}
+	return stats.Clicks.ValueAt(profile, now) / impressions
+}
+
+// propensity returns how likely the position is to be examined, the first
+// position is always examined
+func (m *ClickModel) propensity(position int, profile *DecayProfile, now int64) float64 {
+	top := m.clickRate(0, profile, now)
+	if top <= 0 {
+		return 1
+	}
+	return min(max(m.clickRate(position, profile, now)/top, minPropensity), 1)
+}
+
+func (m *ClickModel) AddImpression(id uint, position float32, now int64) {
+	bucket := positionBucket(position)
+	profile := GetDecayProfile(ProfileClickModel)
+	m.Positions[bucket].Impressions.Add(profile, DecayEvent{TimeStamp: now, Value: 1})
+	m.Examined.Add(id, DecayEvent{TimeStamp: now, Value: m.propensity(bucket, profile, now)})
+}
+
+func (m *ClickModel) AddClick(id uint, position float32, now int64) {
+	bucket := positionBucket(position)
+	m.Positions[bucket].Clicks.Add(GetDecayProfile(ProfileClickModel), DecayEvent{TimeStamp: now, Value: 1})
+	m.Clicks.Add(id, DecayEvent{TimeStamp: now, Value: 1})
+}
+
+func (m *ClickModel) GetPropensities(now int64) []PositionPropensity {
+	profile := GetDecayProfile(ProfileClickModel)
+	result := make([]PositionPropensity, 0, len(m.Positions))
+	for position, stats := range m.Positions {
+		impressions := stats.Impressions.ValueAt(profile, now)
+		if impressions <= 0 {
+			continue
+		}
+		result = append(result, PositionPropensity{
+			Position:    position,
+			Impressions: impressions,
+			Clicks:      stats.Clicks.ValueAt(profile, now),
+			Propensity:  m.propensity(position, profile, now),
+		})
+	}
+	return result
+}
+
+// Attractiveness returns the debiased click rate of every clicked item,
+// smoothed towards the overall click rate
+func (m *ClickModel) Attractiveness(now int64) sorting.SortOverride {
+	profile := GetDecayProfile(ProfileClickModel)
+	result := sorting.SortOverride{}
+	var clicks, examined float64
+	for id, value := range m.Clicks.Values {
+		clicks += value.ValueAt(profile, now)
+		examinedValue := m.Examined.Values[id]
+		examined += examinedValue.ValueAt(profile, now)
+	}
+	if clicks <= 0 {
+		return result
+	}
+	prior := clicks / max(examined, clicks)
+	for id, value := range m.Clicks.Values {
+		itemClicks := value.ValueAt(profile, now)
+		if itemClicks <= 0 {
+			continue
+		}
+		examinedValue := m.Examined.Values[id]
+		itemExamined := examinedValue.ValueAt(profile, now)
+		result[id] = (itemClicks + prior*attractivenessPrior) / (itemExamined + attractivenessPrior)
+	}
+	return result
+}
+
+func (m *ClickModel) compact(now int64) {
+	m.Clicks.Compact(now)
+	m.Examined.Compact(now)
+}
+
+func (s *PersistentMemoryTrackingHandler) GetDebiasedPopularity() sorting.SortOverride {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.ClickModel.Attractiveness(time.Now().Unix())
+}
+
+func (s *PersistentMemoryTrackingHandler) GetPositionPropensities() []PositionPropensity {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.ClickModel.GetPropensities(time.Now().Unix())
+}
+
+// DecayClickModel publishes the debiased attractiveness as popular-debiased
+func (s *PersistentMemoryTrackingHandler) DecayClickModel() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().Unix()
+	s.ClickModel.compact(now)
+	if s.trackingHandler == nil {
+		return
+	}
+	sort := s.ClickModel.Attractiveness(now)
+	go func() {
+		if err := s.trackingHandler.SortOverrideChanged("popular-debiased", &sort); err != nil {
+			log.Printf("Failed to publish debiased popularity: %v", err)
+		}
+	}()
+}
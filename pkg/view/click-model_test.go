@@ -0,0 +1,42 @@
+package view
+
+import (
+	"testing"
+)
+
+func TestClickModelCorrectsPositionBias(t *testing.T) {
+	model := NewClickModel()
+	now := int64(1000)
+	// position 0 is clicked every other time, position 9 every tenth time
+	for i := range 100 {
+		model.AddImpression(uint(100+i%5), 0, now)
+		if i%2 == 0 {
+			model.AddClick(uint(100+i%5), 0, now)
+		}
+		model.AddImpression(1, 9, now)
+		if i%10 == 0 {
+			model.AddClick(1, 9, now)
+		}
+	}
+
+	propensities := model.GetPropensities(now)
+	if len(propensities) != 2 || propensities[0].Propensity != 1 {
+		t.Fatalf("Unexpected propensities %+v", propensities)
+	}
+	if p := propensities[1].Propensity; p < 0.15 || p > 0.25 {
+		t.Errorf("Expected position 9 to be examined about a fifth of the time, got %v", p)
+	}
+
+	attractiveness := model.Attractiveness(now)
+	// item 1 is clicked as often as the top items once the position is
+	// accounted for
+	if attractiveness[1] < attractiveness[100]*0.5 {
+		t.Errorf("Expected item 1 to keep its attractiveness, got %v vs %v", attractiveness[1], attractiveness[100])
+	}
+}
+
+func TestPositionBucket(t *testing.T) {
+	if positionBucket(-1) != 0 || positionBucket(500) != clickModelPositions-1 || positionBucket(3.7) != 3 {
+		t.Errorf("Unexpected position buckets")
+	}
+}
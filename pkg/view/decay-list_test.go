@@ -1,24 +1,93 @@
 package view
 
-import "testing"
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
 
 func TestDecayListAdd(t *testing.T) {
 	list := DecayList{}
 
-	list.Add(1, DecayEvent{TimeStamp: 100, Value: 50.0})
-	list.Add(1, DecayEvent{TimeStamp: 101, Value: 60.0})
-	list.Add(2, DecayEvent{TimeStamp: 102, Value: 70.0})
+	events := []DecayEvent{
+		{TimeStamp: 100, Value: 50.0},
+		{TimeStamp: 101, Value: 60.0},
+	}
+	list.Add(1, events[0])
+	list.Add(1, events[1])
 	list.Add(2, DecayEvent{TimeStamp: 103, Value: 80.0})
+	list.Add(2, DecayEvent{TimeStamp: 102, Value: 70.0})
 
-	if len(list[1]) != 2 {
-		t.Errorf("Expected 2 events for key 1, got %d", len(list[1]))
-	}
-	if len(list[2]) != 2 {
-		t.Errorf("Expected 2 events for key 2, got %d", len(list[2]))
+	if list.Values[1].TimeStamp != 101 || list.Values[2].TimeStamp != 103 {
+		t.Errorf("Expected aggregates to keep the latest timestamp, got %d and %d", list.Values[1].TimeStamp, list.Values[2].TimeStamp)
 	}
 	value := list.Decay(4000)
 	if len(value) != 2 {
 		t.Errorf("Expected 2 events for key 1, got %d", len(value))
 	}
+	expected := events[0].CalculateValue(4000) + events[1].CalculateValue(4000)
+	if math.Abs(value[1]-expected) > 1e-9 {
+		t.Errorf("Expected %v for key 1, got %v", expected, value[1])
+	}
+}
 
+func TestDecayListCompact(t *testing.T) {
+	list := DecayList{}
+	list.Add(1, DecayEvent{TimeStamp: 100, Value: 50.0})
+	list.Add(2, DecayEvent{TimeStamp: 100 + maxAge + 10, Value: 50.0})
+
+	list.Compact(100 + maxAge + 20)
+	if _, ok := list.Values[1]; ok {
+		t.Errorf("Expected expired key to be removed")
+	}
+	if _, ok := list.Values[2]; !ok {
+		t.Errorf("Expected recent key to be kept")
+	}
+}
+
+func TestDecayUnmarshalLegacyEvents(t *testing.T) {
+	var list DecayList
+	if err := json.Unmarshal([]byte(`{"1":[{"ts":100,"value":50},{"ts":200,"value":60}],"2":{"sum":5,"ts":300}}`), &list); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	expected := 50*math.Pow(decayRate, 100) + 60
+	if list.Values[1].TimeStamp != 200 || math.Abs(list.Values[1].Sum-expected) > 1e-9 {
+		t.Errorf("Expected legacy events to be folded, got %+v", list.Values[1])
+	}
+	if list.Values[2].Sum != 5 || list.Values[2].TimeStamp != 300 {
+		t.Errorf("Expected aggregate to be decoded, got %+v", list.Values[2])
+	}
+
+	var popularity DecayPopularity
+	if err := json.Unmarshal([]byte(`{"events":[{"ts":100,"value":2},{"ts":100,"value":3}],"value":4}`), &popularity); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if popularity.Sum != 5 || popularity.TimeStamp != 100 || popularity.Value != 4 {
+		t.Errorf("Expected legacy popularity to be folded, got %+v", popularity)
+	}
+}
+
+func TestDecayValueExpiresOldEvents(t *testing.T) {
+	var value DecayValue
+	var events []DecayEvent
+	start := int64(1700000000)
+	for ts := start; ts < start+2*maxAge; ts += 60 * 60 {
+		event := DecayEvent{TimeStamp: ts, Value: 1}
+		events = append(events, event)
+		value.Add(defaultProfile, event)
+	}
+	if len(value.Days) > maxAge/decayBucket+2 {
+		t.Errorf("Expected expired days to be dropped, got %d days", len(value.Days))
+	}
+	last := events[len(events)-1].TimeStamp
+	for _, now := range []int64{last, last + 10*decayBucket, last + maxAge/2} {
+		expected := 0.0
+		for _, event := range events {
+			expected += event.CalculateValue(now)
+		}
+		got := value.ValueAt(defaultProfile, now)
+		if math.Abs(got-expected) > expected*0.01 {
+			t.Errorf("At %d expected %v got %v", now, expected, got)
+		}
+	}
 }
@@ -0,0 +1,162 @@
+package view
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+const (
+	ProfileDefault  = "default"
+	ProfileItem     = "item"
+	ProfileField    = "field"
+	ProfileQuery    = "query"
+	ProfileFacet    = "facet"
+	ProfileRelation = "relation"
+)
+
+// DecayProfile describes how fast a signal is forgotten, half life and max
+// age are in seconds
+type DecayProfile struct {
+	Name     string `json:"name"`
+	HalfLife int64  `json:"half_life"`
+	MaxAge   int64  `json:"max_age"`
+	rate     float64
+}
+
+func NewDecayProfile(name string, halfLife int64, maxAge int64) (*DecayProfile, error) {
+	if name == "" {
+		return nil, fmt.Errorf("decay profile without name")
+	}
+	if halfLife <= 0 {
+		return nil, fmt.Errorf("decay profile %s: half life must be positive", name)
+	}
+	if maxAge <= 0 {
+		return nil, fmt.Errorf("decay profile %s: max age must be positive", name)
+	}
+	return &DecayProfile{
+		Name:     name,
+		HalfLife: halfLife,
+		MaxAge:   maxAge,
+		rate:     math.Pow(0.5, 1/float64(halfLife)),
+	}, nil
+}
+
+// defaultProfile keeps the decay rate used before profiles existed
+var defaultProfile = &DecayProfile{
+	Name:     ProfileDefault,
+	HalfLife: int64(math.Round(math.Log(0.5) / math.Log(decayRate))),
+	MaxAge:   maxAge,
+	rate:     decayRate,
+}
+
+func (p *DecayProfile) factor(elapsed int64) float64 {
+	return math.Pow(p.rate, float64(elapsed))
+}
+
+// expired reports if the middle of the day is older than the max age at now,
+// events early in the day expire a bit late and late events a bit early
+func (p *DecayProfile) expired(day int64, now int64) bool {
+	return now-(day+decayBucket/2) > p.MaxAge
+}
+
+// Value decays a value from its timestamp to now with the same rules as
+// DecayEvent.CalculateValue
+func (p *DecayProfile) Value(value float64, timeStamp int64, now int64) float64 {
+	timeElapsed := now - timeStamp
+	if timeElapsed < 0 {
+		return value
+	}
+	if timeElapsed > p.MaxAge {
+		return 0
+	}
+	return value * p.factor(timeElapsed)
+}
+
+var (
+	profileMu sync.RWMutex
+	profiles  = map[string]*DecayProfile{}
+	// builtinProfiles are used for the names the configuration leaves out
+	builtinProfiles = windowProfiles()
+)
+
+// GetDecayProfile returns the named profile, names that are neither
+// configured nor built in fall back to the default profile
+func GetDecayProfile(name string) *DecayProfile {
+	profileMu.RLock()
+	defer profileMu.RUnlock()
+	if profile, ok := profiles[name]; ok {
+		return profile
+	}
+	if profile, ok := builtinProfiles[name]; ok {
+		return profile
+	}
+	if profile, ok := profiles[ProfileDefault]; ok {
+		return profile
+	}
+	return defaultProfile
+}
+
+// GetDecayProfiles lists the configured, built in and default profiles
+func GetDecayProfiles() []DecayProfile {
+	profileMu.RLock()
+	defer profileMu.RUnlock()
+	result := make([]DecayProfile, 0, len(profiles)+len(builtinProfiles)+1)
+	if _, ok := profiles[ProfileDefault]; !ok {
+		result = append(result, *defaultProfile)
+	}
+	for name, profile := range builtinProfiles {
+		if _, ok := profiles[name]; !ok {
+			result = append(result, *profile)
+		}
+	}
+	for _, profile := range profiles {
+		result = append(result, *profile)
+	}
+	slices.SortFunc(result, func(a, b DecayProfile) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return result
+}
+
+func SetDecayProfiles(configured []DecayProfile) error {
+	next := make(map[string]*DecayProfile, len(configured))
+	for _, c := range configured {
+		profile, err := NewDecayProfile(c.Name, c.HalfLife, c.MaxAge)
+		if err != nil {
+			return err
+		}
+		next[profile.Name] = profile
+	}
+	profileMu.Lock()
+	profiles = next
+	profileMu.Unlock()
+	return nil
+}
+
+// LoadDecayProfiles reads a json array of profiles, a missing file keeps the
+// default profile for every signal
+func LoadDecayProfiles(path string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		log.Printf("No decay profiles at %s, using default decay", path)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var configured []DecayProfile
+	if err = json.Unmarshal(data, &configured); err != nil {
+		return err
+	}
+	if err = SetDecayProfiles(configured); err != nil {
+		return err
+	}
+	log.Printf("Loaded %d decay profiles from %s", len(configured), path)
+	return nil
+}
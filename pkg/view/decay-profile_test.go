@@ -0,0 +1,77 @@
+package view
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestDefaultProfileMatchesDecayRate(t *testing.T) {
+	event := DecayEvent{TimeStamp: 100, Value: 50.0}
+	got := GetDecayProfile(ProfileItem).Value(event.Value, event.TimeStamp, 5000)
+	if math.Abs(got-event.CalculateValue(5000)) > 1e-9 {
+		t.Errorf("Expected unconfigured profile to use the default decay, got %v", got)
+	}
+}
+
+func TestLoadDecayProfiles(t *testing.T) {
+	t.Cleanup(func() { SetDecayProfiles(nil) })
+	path := filepath.Join(t.TempDir(), "decay-profiles.json")
+	config := `[{"name":"item","half_life":3600,"max_age":86400}]`
+	if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadDecayProfiles(path); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	list := NewDecayList(ProfileItem)
+	list.Add(1, DecayEvent{TimeStamp: 0, Value: 100})
+	if got := list.Decay(3600)[1]; math.Abs(got-50) > 1e-9 {
+		t.Errorf("Expected value to be halved after one half life, got %v", got)
+	}
+	if got := list.Decay(86400 + 1)[1]; got != 0 {
+		t.Errorf("Expected value to expire after max age, got %v", got)
+	}
+
+	popularity := NewDecayPopularity(ProfileQuery)
+	popularity.Add(DecayEvent{TimeStamp: 0, Value: 100})
+	if got := popularity.Decay(3600); got < 99 {
+		t.Errorf("Expected query profile to keep the default decay, got %v", got)
+	}
+	if !slices.ContainsFunc(GetDecayProfiles(), func(p DecayProfile) bool { return p.Name == ProfileItem && p.HalfLife == 3600 }) {
+		t.Errorf("Expected configured item profile, got %+v", GetDecayProfiles())
+	}
+}
+
+func TestInvalidDecayProfile(t *testing.T) {
+	if err := SetDecayProfiles([]DecayProfile{{Name: "item", HalfLife: 0, MaxAge: 10}}); err == nil {
+		t.Errorf("Expected error for profile without half life")
+	}
+}
+
+func TestDecayListKeepsBoundProfile(t *testing.T) {
+	list := NewDecayList(ProfileItem)
+	list.Add(1, DecayEvent{TimeStamp: 100, Value: 1})
+	data, err := json.Marshal(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := DecayList{}
+	if err = json.Unmarshal(data, &loaded); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Profile != ProfileItem || loaded.Values[1].Sum != 1 {
+		t.Errorf("Unexpected list after round trip %+v", loaded)
+	}
+	bound := NewDecayList(ProfileRelation)
+	if err = json.Unmarshal(data, &bound); err != nil {
+		t.Fatal(err)
+	}
+	if bound.Profile != ProfileRelation {
+		t.Errorf("Expected bound profile to be kept, got %s", bound.Profile)
+	}
+}
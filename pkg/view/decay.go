@@ -1,6 +1,9 @@
 package view
 
 import (
+	"bytes"
+	"encoding/json"
+	"maps"
 	"math"
 
 	"github.com/matst80/slask-finder/pkg/sorting"
@@ -44,76 +47,253 @@ func (d *DecayEvent) Decay(now int64) float64 {
 	return v
 }
 
-type DecayArray = []DecayEvent
+// decayBucket is the width of the buckets a DecayValue keeps, events expire
+// a bucket at a time
+const decayBucket = 60 * 60 * 24
+
+func decayDay(ts int64) int64 {
+	return ts - ts%decayBucket
+}
+
+// DecayValue is the sum of all added events decayed to TimeStamp, the time of
+// the latest event, decaying the sum gives the same value as decaying every
+// event on its own. Days keeps the part of the sum added on each day, decayed
+// to TimeStamp as well, so old days can be left out when they expire
+type DecayValue struct {
+	Sum       float64           `json:"sum"`
+	TimeStamp int64             `json:"ts"`
+	Days      map[int64]float64 `json:"days,omitempty"`
+}
+
+func (d *DecayValue) Add(profile *DecayProfile, event DecayEvent) {
+	d.add(profile, decayDay(event.TimeStamp), event.TimeStamp, event.Value)
+}
+
+// Merge adds the events of other, every day keeps its own sum
+func (d *DecayValue) Merge(profile *DecayProfile, other DecayValue) {
+	if other.Days == nil {
+		d.add(profile, decayDay(other.TimeStamp), other.TimeStamp, other.Sum)
+		return
+	}
+	for day, value := range other.Days {
+		d.add(profile, day, other.TimeStamp, value)
+	}
+}
+
+// add adds a value decayed to timeStamp to the sum of the day
+func (d *DecayValue) add(profile *DecayProfile, day int64, timeStamp int64, value float64) {
+	if d.Days == nil {
+		d.Days = make(map[int64]float64)
+		if d.Sum != 0 {
+			// aggregates stored before the days only know the latest event
+			d.Days[decayDay(d.TimeStamp)] = d.Sum
+		}
+	}
+	if timeStamp >= d.TimeStamp {
+		factor := profile.factor(timeStamp - d.TimeStamp)
+		for day, value := range d.Days {
+			d.Days[day] = value * factor
+		}
+		d.TimeStamp = timeStamp
+		d.Days[day] += value
+	} else {
+		d.Days[day] += value * profile.factor(d.TimeStamp-timeStamp)
+	}
+	d.Sum = 0
+	for day, value := range d.Days {
+		if profile.expired(day, d.TimeStamp) {
+			delete(d.Days, day)
+			continue
+		}
+		d.Sum += value
+	}
+}
+
+// ValueAt returns the decayed sum without the days that are past the max age
+// of the profile at now
+func (d *DecayValue) ValueAt(profile *DecayProfile, now int64) float64 {
+	sum := d.Sum
+	for day, value := range d.Days {
+		if profile.expired(day, now) {
+			sum -= value
+		}
+	}
+	return profile.Value(math.Max(sum, 0), d.TimeStamp, now)
+}
+
+// UnmarshalJSON also accepts the event arrays stored before the aggregates
+// and folds them into a single value
+func (d *DecayValue) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		var events []DecayEvent
+		if err := json.Unmarshal(data, &events); err != nil {
+			return err
+		}
+		*d = DecayValue{}
+		for _, event := range events {
+			d.Add(defaultProfile, event)
+		}
+		return nil
+	}
+	type plain DecayValue
+	return json.Unmarshal(data, (*plain)(d))
+}
 
 type DecayPopularity struct {
-	Events DecayArray `json:"events"`
-	Value  float64    `json:"value"`
+	DecayValue
+	Profile string  `json:"profile,omitempty"`
+	Value   float64 `json:"value"`
+}
+
+func NewDecayPopularity(profile string) *DecayPopularity {
+	return &DecayPopularity{Profile: profile}
+}
+
+func (d *DecayPopularity) UnmarshalJSON(data []byte) error {
+	var stored struct {
+		Sum       float64           `json:"sum"`
+		TimeStamp int64             `json:"ts"`
+		Days      map[int64]float64 `json:"days"`
+		Profile   string            `json:"profile"`
+		Value     float64           `json:"value"`
+		Events    []DecayEvent      `json:"events"`
+	}
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return err
+	}
+	d.DecayValue = DecayValue{Sum: stored.Sum, TimeStamp: stored.TimeStamp, Days: stored.Days}
+	for _, event := range stored.Events {
+		d.DecayValue.Add(defaultProfile, event)
+	}
+	if stored.Profile != "" {
+		d.Profile = stored.Profile
+	}
+	d.Value = stored.Value
+	return nil
 }
 
 func (d *DecayPopularity) Add(value DecayEvent) {
-	if d.Events == nil {
-		d.Events = make([]DecayEvent, 0)
+	d.DecayValue.Add(GetDecayProfile(d.Profile), value)
+}
+
+// Merge adds the events of other, decayed with the profile of d
+func (d *DecayPopularity) Merge(other *DecayPopularity) {
+	if other == nil {
+		return
 	}
-	d.Events = append(d.Events, value)
+	d.DecayValue.Merge(GetDecayProfile(d.Profile), other.DecayValue)
+	d.Value += other.Value
 }
 
 func (d *DecayPopularity) Decay(now int64) float64 {
+	d.Value = d.ValueAt(GetDecayProfile(d.Profile), now)
+	return d.Value
+}
 
-	var popularity float64
+// DecayList keeps one decayed value per key, all values decay with the
+// profile the list is bound to
+type DecayList struct {
+	Profile string
+	Values  map[uint]DecayValue
+}
 
-	for _, event := range d.Events {
-		popularity += event.Decay(now)
+func NewDecayList(profile string) DecayList {
+	return DecayList{
+		Profile: profile,
+		Values:  make(map[uint]DecayValue),
 	}
-	d.Value = popularity
-	return popularity
 }
 
-func (d *DecayPopularity) RemoveOlderThan(when int64) {
-	end := len(d.Events)
-
-	for i, e := range d.Events {
-		if e.TimeStamp >= when {
-			end = i
-		}
+// Bind makes the list decay with the named profile
+func (d *DecayList) Bind(profile string) {
+	d.Profile = profile
+	if d.Values == nil {
+		d.Values = make(map[uint]DecayValue)
 	}
-	d.Events = d.Events[:end]
 }
 
-type DecayList map[uint]DecayArray
+func (d *DecayList) Len() int {
+	return len(d.Values)
+}
 
 func (d *DecayList) Add(key uint, value DecayEvent) {
-	f, ok := (*d)[key]
-	if !ok {
-		(*d)[key] = []DecayEvent{
-			value,
-		}
-	} else {
-		//f = append(f, value)
-		(*d)[key] = append(f, value)
+	if d.Values == nil {
+		d.Values = make(map[uint]DecayValue)
+	}
+	f := d.Values[key]
+	f.Add(GetDecayProfile(d.Profile), value)
+	d.Values[key] = f
+}
+
+// Merge adds the values of other key by key
+func (d *DecayList) Merge(other DecayList) {
+	if d.Values == nil {
+		d.Values = make(map[uint]DecayValue)
+	}
+	profile := GetDecayProfile(d.Profile)
+	for key, value := range other.Values {
+		f := d.Values[key]
+		f.Merge(profile, value)
+		d.Values[key] = f
 	}
 }
 
 func (d *DecayList) Decay(now int64) sorting.SortOverride {
 	result := sorting.SortOverride{}
+	profile := GetDecayProfile(d.Profile)
 	var popularity float64
-	var event DecayEvent
 
-	for itemId, events := range *d {
-		popularity = 0
-		for _, event = range events {
-			popularity += event.Decay(now)
-		}
+	for itemId, value := range d.Values {
+		popularity = value.ValueAt(profile, now)
 		if popularity < 0.002 {
 			continue
 		}
 		result[itemId] = popularity
 
 	}
-	// *d = slices.DeleteFunc(d, func(i DecayEvent) bool {
-	// 	// log.Printf("Deleting value popularity %s for query %s, value:%f", key, q, value.Value)
-	// 	return i.Value < 0.0002
-	// })
 
 	return result
 }
+
+// Compact removes the keys that have decayed below what Decay reports
+func (d *DecayList) Compact(now int64) {
+	profile := GetDecayProfile(d.Profile)
+	maps.DeleteFunc(d.Values, func(key uint, value DecayValue) bool {
+		return value.ValueAt(profile, now) < 0.0002
+	})
+}
+
+type storedDecayList struct {
+	Profile string              `json:"profile,omitempty"`
+	Values  map[uint]DecayValue `json:"values"`
+}
+
+func (d DecayList) MarshalJSON() ([]byte, error) {
+	return json.Marshal(storedDecayList{Profile: d.Profile, Values: d.Values})
+}
+
+// UnmarshalJSON also reads lists stored as a plain map, a profile bound before
+// loading is kept
+func (d *DecayList) UnmarshalJSON(data []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	var stored storedDecayList
+	if _, ok := fields["values"]; ok {
+		if err := json.Unmarshal(data, &stored); err != nil {
+			return err
+		}
+	} else if err := json.Unmarshal(data, &stored.Values); err != nil {
+		return err
+	}
+	if d.Profile == "" {
+		d.Profile = stored.Profile
+	}
+	d.Values = stored.Values
+	if d.Values == nil {
+		d.Values = make(map[uint]DecayValue)
+	}
+	return nil
+}
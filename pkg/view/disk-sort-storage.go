@@ -2,6 +2,8 @@ package view
 
 import (
 	"fmt"
+	"hash/fnv"
+	"net/url"
 	"os"
 
 	"github.com/matst80/slask-finder/pkg/sorting"
@@ -18,8 +20,28 @@ func DiskPopularityListener(path string) *DiskOverrideStorage {
 	}
 }
 
+// maxFileNameLength keeps escaped keys below the file name limit of most
+// file systems
+const maxFileNameLength = 200
+
+// fileName escapes keys like query-{query} that can contain path separators,
+// long keys are cut and suffixed with a hash of the full key
+func fileName(key string) string {
+	name := url.PathEscape(key)
+	switch name {
+	case "", ".", "..":
+		name = url.PathEscape("%" + name)
+	}
+	if len(name) > maxFileNameLength {
+		hash := fnv.New64a()
+		hash.Write([]byte(key))
+		name = fmt.Sprintf("%s-%x", name[:maxFileNameLength-17], hash.Sum64())
+	}
+	return name
+}
+
 func (s *DiskOverrideStorage) saveToFile(filename string, data string) error {
-	filePath := fmt.Sprintf("%s/%s", s.path, filename)
+	filePath := fmt.Sprintf("%s/%s", s.path, fileName(filename))
 	file, err := os.Create(filePath)
 	if err != nil {
 		return err
@@ -42,6 +64,16 @@ func (s *DiskOverrideStorage) FieldPopularityChanged(sort *sorting.SortOverride)
 	return s.saveToFile("popular-fields", data)
 }
 
+func (s *DiskOverrideStorage) SortOverrideChanged(key string, sort *sorting.SortOverride) error {
+	data := sort.ToString()
+	return s.saveToFile(key, data)
+}
+
+func (s *DiskOverrideStorage) FieldSortOverrideChanged(key string, sort *sorting.SortOverride) error {
+	data := sort.ToString()
+	return s.saveToFile(key, data)
+}
+
 func (s *DiskOverrideStorage) SessionPopularityChanged(sessionId int64, sort *sorting.SortOverride) error {
 	data := sort.ToString()
 	return s.saveToFile(fmt.Sprintf("session-items-%d", sessionId), data)
@@ -0,0 +1,189 @@
+package view
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+)
+
+// Weight is the value an event adds to a decayed list, the position is
+// clamped between min and max position before it is weighted
+type Weight struct {
+	Base        float64 `json:"base"`
+	PerQuantity float64 `json:"per_quantity,omitempty"`
+	MinQuantity uint    `json:"min_quantity,omitempty"`
+	PerPosition float64 `json:"per_position,omitempty"`
+	MinPosition float64 `json:"min_position,omitempty"`
+	MaxPosition float64 `json:"max_position,omitempty"`
+}
+
+func (w Weight) Value(quantity uint, position float32) float64 {
+	pos := max(float64(position), w.MinPosition)
+	if w.MaxPosition > 0 {
+		pos = min(pos, w.MaxPosition)
+	}
+	return w.Base + w.PerQuantity*float64(max(quantity, w.MinQuantity)) + w.PerPosition*pos
+}
+
+func (w Weight) validate() error {
+	if w.Base < 0 || w.PerQuantity < 0 || w.PerPosition < 0 {
+		return fmt.Errorf("negative weight %+v", w)
+	}
+	if w.MaxPosition > 0 && w.MaxPosition < w.MinPosition {
+		return fmt.Errorf("max position below min position %+v", w)
+	}
+	return nil
+}
+
+type WeightTable struct {
+	Click          Weight `json:"click"`
+	Impression     Weight `json:"impression"`
+	Action         Weight `json:"action"`
+	Cart           Weight `json:"cart"`
+	Checkout       Weight `json:"checkout"`
+	Purchase       Weight `json:"purchase"`
+	Query          Weight `json:"query"`
+	QueryFacet     Weight `json:"query_facet"`
+	Filter         Weight `json:"filter"`
+	FilterValue    Weight `json:"filter_value"`
+	RangeFilter    Weight `json:"range_filter"`
+	ViewedTogether Weight `json:"viewed_together"`
+	BoughtTogether Weight `json:"bought_together"`
+}
+
+func (t WeightTable) validate() error {
+	for name, w := range map[string]Weight{
+		"click":           t.Click,
+		"impression":      t.Impression,
+		"action":          t.Action,
+		"cart":            t.Cart,
+		"checkout":        t.Checkout,
+		"purchase":        t.Purchase,
+		"query":           t.Query,
+		"query_facet":     t.QueryFacet,
+		"filter":          t.Filter,
+		"filter_value":    t.FilterValue,
+		"range_filter":    t.RangeFilter,
+		"viewed_together": t.ViewedTogether,
+		"bought_together": t.BoughtTogether,
+	} {
+		if err := w.validate(); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// EventWeights holds the weights for the global lists, the session lists and
+// the personalization group lists
+type EventWeights struct {
+	Global  WeightTable `json:"global"`
+	Session WeightTable `json:"session"`
+	Group   WeightTable `json:"group"`
+}
+
+func (w EventWeights) Validate() error {
+	if err := w.Global.validate(); err != nil {
+		return fmt.Errorf("global %w", err)
+	}
+	if err := w.Session.validate(); err != nil {
+		return fmt.Errorf("session %w", err)
+	}
+	if err := w.Group.validate(); err != nil {
+		return fmt.Errorf("group %w", err)
+	}
+	return nil
+}
+
+func DefaultEventWeights() EventWeights {
+	return EventWeights{
+		Global: WeightTable{
+			Click:          Weight{Base: 200, PerPosition: 0.1, MaxPosition: 300},
+			Impression:     Weight{PerPosition: 1},
+			Action:         Weight{Base: 30},
+			Cart:           Weight{PerQuantity: 190},
+			Checkout:       Weight{PerQuantity: 200},
+			Purchase:       Weight{PerQuantity: 800, MinQuantity: 1},
+			Query:          Weight{Base: 20},
+			QueryFacet:     Weight{Base: 100},
+			Filter:         Weight{Base: 40},
+			FilterValue:    Weight{Base: 80},
+			RangeFilter:    Weight{Base: 30},
+			ViewedTogether: Weight{Base: 20},
+			BoughtTogether: Weight{Base: 100},
+		},
+		Session: WeightTable{
+			Click:       Weight{Base: 200},
+			Impression:  Weight{Base: 10, PerPosition: 0.02, MinPosition: 300},
+			Action:      Weight{Base: 80},
+			Cart:        Weight{Base: 700},
+			Purchase:    Weight{PerQuantity: 800},
+			Filter:      Weight{Base: 150},
+			RangeFilter: Weight{Base: 100},
+		},
+		Group: WeightTable{
+			Click:       Weight{Base: 200},
+			Impression:  Weight{PerPosition: 0.02, MinPosition: 300},
+			Action:      Weight{Base: 80},
+			Cart:        Weight{Base: 700},
+			Purchase:    Weight{PerQuantity: 800},
+			Filter:      Weight{Base: 150},
+			RangeFilter: Weight{Base: 100},
+		},
+	}
+}
+
+var (
+	weightMu     sync.RWMutex
+	eventWeights = DefaultEventWeights()
+	weightsPath  string
+)
+
+func GetEventWeights() EventWeights {
+	weightMu.RLock()
+	defer weightMu.RUnlock()
+	return eventWeights
+}
+
+// SetEventWeights replaces the weights used for new events and writes them to
+// the file they were loaded from
+func SetEventWeights(weights EventWeights) error {
+	if err := weights.Validate(); err != nil {
+		return err
+	}
+	weightMu.Lock()
+	defer weightMu.Unlock()
+	if weightsPath != "" {
+		if err := writeSnapshot(weightsPath, weights); err != nil {
+			return err
+		}
+	}
+	eventWeights = weights
+	return nil
+}
+
+// LoadEventWeights reads weights on top of the defaults, so the file only
+// needs the weights that differ, a missing file keeps the defaults
+func LoadEventWeights(path string) error {
+	weights := DefaultEventWeights()
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		if err = json.Unmarshal(data, &weights); err != nil {
+			return err
+		}
+		if err = weights.Validate(); err != nil {
+			return err
+		}
+		log.Printf("Loaded event weights from %s", path)
+	}
+	weightMu.Lock()
+	defer weightMu.Unlock()
+	eventWeights = weights
+	weightsPath = path
+	return nil
+}
@@ -0,0 +1,78 @@
+package view
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func resetEventWeights(t *testing.T) {
+	t.Cleanup(func() {
+		weightMu.Lock()
+		eventWeights = DefaultEventWeights()
+		weightsPath = ""
+		weightMu.Unlock()
+	})
+}
+
+func TestDefaultEventWeights(t *testing.T) {
+	weights := DefaultEventWeights()
+	tests := []struct {
+		name     string
+		weight   Weight
+		quantity uint
+		position float32
+		want     float64
+	}{
+		{"click", weights.Global.Click, 0, 10, 201},
+		{"click capped position", weights.Global.Click, 0, 500, 230},
+		{"cart", weights.Global.Cart, 2, 0, 380},
+		{"purchase without quantity", weights.Global.Purchase, 0, 0, 800},
+		{"session impression", weights.Session.Impression, 0, 5, 16},
+		{"group impression", weights.Group.Impression, 0, 400, 8},
+		{"session purchase", weights.Session.Purchase, 3, 0, 2400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.weight.Value(tt.quantity, tt.position); got != tt.want {
+				t.Errorf("Value() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetEventWeightsAppliesToNewEvents(t *testing.T) {
+	resetEventWeights(t)
+	path := filepath.Join(t.TempDir(), "event-weights.json")
+	if err := os.WriteFile(path, []byte(`{"global":{"cart":{"per_quantity":300}}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadEventWeights(path); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	weights := GetEventWeights()
+	if weights.Global.Cart.PerQuantity != 300 || weights.Global.Click.Base != 200 {
+		t.Errorf("Expected loaded weights on top of the defaults, got %+v", weights.Global)
+	}
+
+	handler := MakeMemoryTrackingHandler(filepath.Join(t.TempDir(), "tracking.json"), 500)
+	weights.Global.Action = Weight{Base: 55}
+	if err := SetEventWeights(weights); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	handler.HandleActionEvent(ActionEvent{
+		BaseEvent: &BaseEvent{Event: EVENT_ITEM_ACTION, SessionId: 1},
+		BaseItem:  &BaseItem{Id: 3},
+		Action:    "wishlist",
+	}, nil)
+	if got := handler.ItemEvents.Values[3].Sum; got != 55 {
+		t.Errorf("Expected updated action weight, got %v", got)
+	}
+	if err := LoadEventWeights(path); err != nil || GetEventWeights().Global.Action.Base != 55 {
+		t.Errorf("Expected updated weights to be written to the config file")
+	}
+	weights.Global.Cart.Base = -1
+	if err := SetEventWeights(weights); err == nil {
+		t.Errorf("Expected negative weight to be rejected")
+	}
+}
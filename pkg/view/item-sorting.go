@@ -3,16 +3,14 @@ package view
 import (
 	"context"
 	"fmt"
-	"log"
 
-	"github.com/matst80/slask-finder/pkg/messaging"
 	"github.com/matst80/slask-finder/pkg/sorting"
 	"github.com/matst80/slask-finder/pkg/types"
-	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/matst80/slask-tracking/pkg/broker"
 )
 
 type SortOverrideStorage struct {
-	conn        *amqp.Connection
+	transport   broker.Transport
 	ctx         context.Context
 	diskStorage *DiskOverrideStorage
 }
@@ -28,23 +26,11 @@ type SortOverrideStorage struct {
 // const REDIS_GROUP_POPULAR_CHANGE = "groupChange"
 // const REDIS_GROUP_FIELD_CHANGE = "groupFieldChange"
 
-func NewSortOverrideStorage(conn *amqp.Connection) *SortOverrideStorage {
+func NewSortOverrideStorage(transport broker.Transport) *SortOverrideStorage {
 	ctx := context.Background()
 	diskStorage := DiskPopularityListener("data/overrides")
-	ch, err := conn.Channel()
-	if err != nil {
-		log.Fatalf("Unable to create channel %v", err)
-	}
-	err = messaging.DefineTopic(ch, "global", "sort_override")
-	if err != nil {
-		log.Fatalf("Unable to define topic %v", err)
-	}
-	err = messaging.DefineTopic(ch, "global", "field_sort_override")
-	if err != nil {
-		log.Fatalf("Unable to define topic %v", err)
-	}
 	return &SortOverrideStorage{
-		conn:        conn,
+		transport:   transport,
 		ctx:         ctx,
 		diskStorage: diskStorage,
 	}
@@ -52,7 +38,7 @@ func NewSortOverrideStorage(conn *amqp.Connection) *SortOverrideStorage {
 
 func (s *SortOverrideStorage) PopularityChanged(sort *sorting.SortOverride) error {
 	s.diskStorage.PopularityChanged(sort)
-	messaging.SendChange(s.conn, "global", "sort_override", types.SortOverrideUpdate{
+	s.transport.SendChange("sort_override", "popular", types.SortOverrideUpdate{
 		Key:  "popular",
 		Data: *sort,
 	})
@@ -61,12 +47,28 @@ func (s *SortOverrideStorage) PopularityChanged(sort *sorting.SortOverride) erro
 
 func (s *SortOverrideStorage) FieldPopularityChanged(sort *sorting.SortOverride) error {
 	s.diskStorage.FieldPopularityChanged(sort)
-	return messaging.SendChange(s.conn, "global", "field_sort_override", types.SortOverrideUpdate{
+	return s.transport.SendChange("field_sort_override", "popular-fields", types.SortOverrideUpdate{
 		Key:  "popular-fields",
 		Data: *sort,
 	})
 }
 
+func (s *SortOverrideStorage) SortOverrideChanged(key string, sort *sorting.SortOverride) error {
+	s.diskStorage.SortOverrideChanged(key, sort)
+	return s.transport.SendChange("sort_override", key, types.SortOverrideUpdate{
+		Key:  key,
+		Data: *sort,
+	})
+}
+
+func (s *SortOverrideStorage) FieldSortOverrideChanged(key string, sort *sorting.SortOverride) error {
+	s.diskStorage.FieldSortOverrideChanged(key, sort)
+	return s.transport.SendChange("field_sort_override", key, types.SortOverrideUpdate{
+		Key:  key,
+		Data: *sort,
+	})
+}
+
 func (s *SortOverrideStorage) SessionPopularityChanged(sessionId int64, sort *sorting.SortOverride) error {
 	return s.diskStorage.SessionPopularityChanged(sessionId, sort)
 	// return messaging.SendChange(s.conn, "global", "sort_override", types.SortOverrideUpdate{
@@ -85,16 +87,18 @@ func (s *SortOverrideStorage) SessionFieldPopularityChanged(sessionId int64, sor
 
 func (s *SortOverrideStorage) GroupPopularityChanged(groupId string, sort *sorting.SortOverride) error {
 	s.diskStorage.GroupPopularityChanged(groupId, sort)
-	return messaging.SendChange(s.conn, "global", "sort_override", types.SortOverrideUpdate{
-		Key:  fmt.Sprintf("group-%s", groupId),
+	key := fmt.Sprintf("group-%s", groupId)
+	return s.transport.SendChange("sort_override", key, types.SortOverrideUpdate{
+		Key:  key,
 		Data: *sort,
 	})
 }
 
 func (s *SortOverrideStorage) GroupFieldPopularityChanged(groupId string, sort *sorting.SortOverride) error {
 	s.diskStorage.GroupFieldPopularityChanged(groupId, sort)
-	return messaging.SendChange(s.conn, "global", "sort_override", types.SortOverrideUpdate{
-		Key:  fmt.Sprintf("group-fields-%s", groupId),
+	key := fmt.Sprintf("group-fields-%s", groupId)
+	return s.transport.SendChange("sort_override", key, types.SortOverrideUpdate{
+		Key:  key,
 		Data: *sort,
 	})
 }
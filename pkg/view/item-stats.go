@@ -0,0 +1,191 @@
+package view
+
+import (
+	"log"
+	"time"
+
+	"github.com/matst80/slask-finder/pkg/sorting"
+)
+
+const (
+	ProfileImpression = "impression"
+	ProfileClick      = "click"
+	ProfileCart       = "cart"
+	ProfileCheckout   = "checkout"
+	// items need this many trials to take part in fitting the prior
+	priorMinTrials = 10
+	// fallback prior strength when the catalogue has too few items to fit on
+	priorStrength = 10
+)
+
+// ItemStats keeps decayed event counts per item, one list per event type
+type ItemStats struct {
+	Impressions DecayList `json:"impressions"`
+	Clicks      DecayList `json:"clicks"`
+	Carts       DecayList `json:"carts"`
+	Checkouts   DecayList `json:"checkouts"`
+}
+
+func NewItemStats() *ItemStats {
+	return &ItemStats{
+		Impressions: NewDecayList(ProfileImpression),
+		Clicks:      NewDecayList(ProfileClick),
+		Carts:       NewDecayList(ProfileCart),
+		Checkouts:   NewDecayList(ProfileCheckout),
+	}
+}
+
+func (s *ItemStats) bind() {
+	s.Impressions.Bind(ProfileImpression)
+	s.Clicks.Bind(ProfileClick)
+	s.Carts.Bind(ProfileCart)
+	s.Checkouts.Bind(ProfileCheckout)
+}
+
+func (s *ItemStats) compact(now int64) {
+	s.Impressions.Compact(now)
+	s.Clicks.Compact(now)
+	s.Carts.Compact(now)
+	s.Checkouts.Compact(now)
+}
+
+// BetaPrior is the prior for a rate, the smoothed rate is
+// (successes + alpha) / (trials + alpha + beta)
+type BetaPrior struct {
+	Alpha float64 `json:"alpha"`
+	Beta  float64 `json:"beta"`
+}
+
+func (p BetaPrior) Smooth(successes float64, trials float64) float64 {
+	return (successes + p.Alpha) / (trials + p.Alpha + p.Beta)
+}
+
+// fitBetaPrior estimates the prior with the method of moments from the rates
+// of the items with enough trials
+func fitBetaPrior(successes map[uint]float64, trials map[uint]float64) BetaPrior {
+	rates := make([]float64, 0, len(trials))
+	var totalSuccesses, totalTrials float64
+	for id, n := range trials {
+		k := min(successes[id], n)
+		totalSuccesses += k
+		totalTrials += n
+		if n >= priorMinTrials {
+			rates = append(rates, k/n)
+		}
+	}
+	if totalTrials <= 0 {
+		return BetaPrior{Alpha: 1, Beta: 1}
+	}
+	mean := totalSuccesses / totalTrials
+	fallback := BetaPrior{Alpha: mean * priorStrength, Beta: (1 - mean) * priorStrength}
+	if len(rates) < 2 || mean <= 0 || mean >= 1 {
+		return fallback
+	}
+	var sum, sumSquares float64
+	for _, rate := range rates {
+		sum += rate
+	}
+	sampleMean := sum / float64(len(rates))
+	for _, rate := range rates {
+		sumSquares += (rate - sampleMean) * (rate - sampleMean)
+	}
+	variance := sumSquares / float64(len(rates)-1)
+	if variance <= 0 || variance >= sampleMean*(1-sampleMean) {
+		return fallback
+	}
+	common := sampleMean*(1-sampleMean)/variance - 1
+	return BetaPrior{Alpha: sampleMean * common, Beta: (1 - sampleMean) * common}
+}
+
+type ItemRates struct {
+	Ctr          BetaPrior `json:"ctr"`
+	CartRate     BetaPrior `json:"cart_rate"`
+	CheckoutRate BetaPrior `json:"checkout_rate"`
+}
+
+type ItemStatsResult struct {
+	Id           uint      `json:"id"`
+	Impressions  float64   `json:"impressions"`
+	Clicks       float64   `json:"clicks"`
+	Carts        float64   `json:"carts"`
+	Checkouts    float64   `json:"checkouts"`
+	Ctr          float64   `json:"ctr"`
+	CartRate     float64   `json:"cart_rate"`
+	CheckoutRate float64   `json:"checkout_rate"`
+	Priors       ItemRates `json:"priors"`
+}
+
+type itemCounts struct {
+	impressions sorting.SortOverride
+	clicks      sorting.SortOverride
+	carts       sorting.SortOverride
+	checkouts   sorting.SortOverride
+}
+
+func (s *ItemStats) counts(now int64) itemCounts {
+	return itemCounts{
+		impressions: s.Impressions.Decay(now),
+		clicks:      s.Clicks.Decay(now),
+		carts:       s.Carts.Decay(now),
+		checkouts:   s.Checkouts.Decay(now),
+	}
+}
+
+// priors fits ctr on clicks per impression, cart rate on carts per click and
+// checkout rate on checkouts per cart
+func (c itemCounts) priors() ItemRates {
+	return ItemRates{
+		Ctr:          fitBetaPrior(c.clicks, c.impressions),
+		CartRate:     fitBetaPrior(c.carts, c.clicks),
+		CheckoutRate: fitBetaPrior(c.checkouts, c.carts),
+	}
+}
+
+func (c itemCounts) result(id uint, priors ItemRates) ItemStatsResult {
+	return ItemStatsResult{
+		Id:           id,
+		Impressions:  c.impressions[id],
+		Clicks:       c.clicks[id],
+		Carts:        c.carts[id],
+		Checkouts:    c.checkouts[id],
+		Ctr:          priors.Ctr.Smooth(c.clicks[id], c.impressions[id]),
+		CartRate:     priors.CartRate.Smooth(c.carts[id], c.clicks[id]),
+		CheckoutRate: priors.CheckoutRate.Smooth(c.checkouts[id], c.carts[id]),
+		Priors:       priors,
+	}
+}
+
+func (s *PersistentMemoryTrackingHandler) GetItemStats(id uint) ItemStatsResult {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	counts := s.ItemStats.counts(time.Now().Unix())
+	return counts.result(id, counts.priors())
+}
+
+// getCtr returns the smoothed ctr of every item with impressions
+func (s *PersistentMemoryTrackingHandler) getCtr(now int64) sorting.SortOverride {
+	counts := s.ItemStats.counts(now)
+	prior := fitBetaPrior(counts.clicks, counts.impressions)
+	result := make(sorting.SortOverride, len(counts.impressions))
+	for id, impressions := range counts.impressions {
+		result[id] = prior.Smooth(counts.clicks[id], impressions)
+	}
+	return result
+}
+
+// DecayItemStats publishes the smoothed ctr as the ctr sort override
+func (s *PersistentMemoryTrackingHandler) DecayItemStats() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().Unix()
+	s.ItemStats.compact(now)
+	if s.trackingHandler == nil {
+		return
+	}
+	sort := s.getCtr(now)
+	go func() {
+		if err := s.trackingHandler.SortOverrideChanged("ctr", &sort); err != nil {
+			log.Printf("Failed to publish ctr: %v", err)
+		}
+	}()
+}
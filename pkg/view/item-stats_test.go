@@ -0,0 +1,70 @@
+package view
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+func TestFitBetaPrior(t *testing.T) {
+	trials := map[uint]float64{1: 100, 2: 100, 3: 100, 4: 100, 5: 2}
+	successes := map[uint]float64{1: 2, 2: 4, 3: 6, 4: 8, 5: 2}
+	prior := fitBetaPrior(successes, trials)
+	mean := prior.Alpha / (prior.Alpha + prior.Beta)
+	if math.Abs(mean-0.05) > 1e-9 {
+		t.Errorf("Expected prior mean 0.05, got %v", mean)
+	}
+	// the item with two clicks out of two impressions is pulled towards the mean
+	if smoothed := prior.Smooth(2, 2); smoothed > 0.5 {
+		t.Errorf("Expected smoothed rate well below 1, got %v", smoothed)
+	}
+
+	fallback := fitBetaPrior(map[uint]float64{1: 1}, map[uint]float64{1: 4})
+	if fallback.Alpha != 2.5 || fallback.Beta != 7.5 {
+		t.Errorf("Expected fallback prior around the mean, got %+v", fallback)
+	}
+}
+
+func TestItemStatsCountsEventTypes(t *testing.T) {
+	handler := MakeMemoryTrackingHandler(filepath.Join(t.TempDir(), "tracking.json"), 500)
+	handler.HandleImpressionEvent(ImpressionEvent{
+		BaseEvent: &BaseEvent{Event: EVENT_ITEM_IMPRESS, SessionId: 1},
+		Items:     []BaseItem{{Id: 1}, {Id: 2, Position: 1}},
+	}, nil)
+	handler.HandleEvent(Event{
+		BaseEvent: &BaseEvent{Event: EVENT_ITEM_CLICK, SessionId: 1},
+		BaseItem:  &BaseItem{Id: 1},
+	}, nil)
+	handler.HandleCartEvent(CartEvent{
+		BaseEvent: &BaseEvent{Event: CART_ADD, SessionId: 1},
+		BaseItem:  &BaseItem{Id: 1, Quantity: 1},
+	}, nil)
+	handler.HandleCartEvent(CartEvent{
+		BaseEvent: &BaseEvent{Event: CART_REMOVE, SessionId: 1},
+		BaseItem:  &BaseItem{Id: 1, Quantity: 1},
+	}, nil)
+
+	stats := handler.GetItemStats(1)
+	if stats.Impressions != 1 || stats.Clicks != 1 || stats.Carts != 1 || stats.Checkouts != 0 {
+		t.Errorf("Unexpected counts %+v", stats)
+	}
+	if stats.Ctr <= handler.GetItemStats(2).Ctr {
+		t.Errorf("Expected clicked item to have the higher ctr")
+	}
+}
+
+func TestItemStatsCountsLegacyCartAdd(t *testing.T) {
+	handler := MakeMemoryTrackingHandler(filepath.Join(t.TempDir(), "tracking.json"), 500)
+	handler.HandleCartEvent(CartEvent{
+		BaseEvent: &BaseEvent{Event: CART_LEGACY_ADD, SessionId: 1},
+		BaseItem:  &BaseItem{Id: 1, Quantity: 1},
+	}, nil)
+	handler.HandleCartEvent(CartEvent{
+		BaseEvent: &BaseEvent{Event: CART_LEGACY_REMOVE, SessionId: 1},
+		BaseItem:  &BaseItem{Id: 1, Quantity: 1},
+	}, nil)
+
+	if stats := handler.GetItemStats(1); stats.Carts != 1 {
+		t.Errorf("Expected the legacy add to be counted as one cart, got %+v", stats)
+	}
+}
@@ -0,0 +1,354 @@
+package view
+
+import (
+	"archive/tar"
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// judgements are counted per day so an export can be limited to a range
+	judgementBucket = 86400
+	judgementMaxAge = 30 * judgementBucket
+	// clicks on at least this share of the impressions are a strong signal
+	judgementHighCtr = 0.2
+)
+
+const (
+	JudgementFormatSVMRank  = "svmrank"
+	JudgementFormatLightGBM = "lightgbm"
+	JudgementFormatJSONL    = "jsonl"
+)
+
+const (
+	// LightGBM reads the group sizes from a file named after the data file
+	lightGBMDataFile  = "judgements.train"
+	lightGBMGroupFile = lightGBMDataFile + ".query"
+)
+
+type JudgementCounts struct {
+	Impressions uint    `json:"impressions,omitempty"`
+	PositionSum float64 `json:"position_sum,omitempty"`
+	Clicks      uint    `json:"clicks,omitempty"`
+	Carts       uint    `json:"carts,omitempty"`
+	Purchases   uint    `json:"purchases,omitempty"`
+	Positive    uint    `json:"positive,omitempty"`
+	Negative    uint    `json:"negative,omitempty"`
+}
+
+func (c *JudgementCounts) merge(other *JudgementCounts) {
+	c.Impressions += other.Impressions
+	c.PositionSum += other.PositionSum
+	c.Clicks += other.Clicks
+	c.Carts += other.Carts
+	c.Purchases += other.Purchases
+	c.Positive += other.Positive
+	c.Negative += other.Negative
+}
+
+func (c *JudgementCounts) engaged() bool {
+	return c.Clicks > 0 || c.Carts > 0 || c.Purchases > 0 || c.Positive > 0 || c.Negative > 0
+}
+
+// QueryJudgements holds the searches for a normalized query and the
+// interactions with the items shown for it, both keyed by day
+type QueryJudgements struct {
+	Searches map[int64]uint                      `json:"searches"`
+	Items    map[uint]map[int64]*JudgementCounts `json:"items"`
+}
+
+func judgementDay(ts int64) int64 {
+	return ts - ts%judgementBucket
+}
+
+func (s *PersistentMemoryTrackingHandler) queryJudgements(query string) *QueryJudgements {
+	if s.Judgements == nil {
+		s.Judgements = make(map[string]*QueryJudgements)
+	}
+	judgements, ok := s.Judgements[query]
+	if !ok {
+		judgements = &QueryJudgements{
+			Searches: make(map[int64]uint),
+			Items:    make(map[uint]map[int64]*JudgementCounts),
+		}
+		s.Judgements[query] = judgements
+	}
+	return judgements
+}
+
+func (s *PersistentMemoryTrackingHandler) itemJudgement(query string, id uint, ts int64) *JudgementCounts {
+	judgements := s.queryJudgements(query)
+	days, ok := judgements.Items[id]
+	if !ok {
+		days = make(map[int64]*JudgementCounts)
+		judgements.Items[id] = days
+	}
+	day := judgementDay(ts)
+	counts, ok := days[day]
+	if !ok {
+		counts = &JudgementCounts{}
+		days[day] = counts
+	}
+	return counts
+}
+
+func (s *PersistentMemoryTrackingHandler) addJudgementSearch(query string, ts int64) {
+	s.queryJudgements(query).Searches[judgementDay(ts)]++
+}
+
+// sessionJudgement returns the counts of the item under the latest search in
+// the session, or nil when the event can not be tied to a search
+func (s *PersistentMemoryTrackingHandler) sessionJudgement(session *SessionData, id uint, ts int64) *JudgementCounts {
+	if session == nil || session.LastSearch == nil || id == 0 {
+		return nil
+	}
+	if ts-session.LastSearch.TimeStamp > queryAttributionWindow {
+		return nil
+	}
+	return s.itemJudgement(session.LastSearch.Query, id, ts)
+}
+
+// addDataSetJudgement counts the explicit judgement, positive and negative
+// are lists of item ids
+func (s *PersistentMemoryTrackingHandler) addDataSetJudgement(event DataSetEvent, ts int64) {
+	query := NormalizeQuery(event.Query, event.GetCountry())
+	if query == "" || classifyQuery(event.Query, query) != "" {
+		return
+	}
+	s.rememberQueryCountry(query, event.GetCountry())
+	if event.BaseEvent != nil && event.TimeStamp > 0 {
+		ts = event.TimeStamp
+	}
+	for _, id := range parseItemIds(event.Positive) {
+		s.itemJudgement(query, id, ts).Positive++
+	}
+	for _, id := range parseItemIds(event.Negative) {
+		s.itemJudgement(query, id, ts).Negative++
+	}
+}
+
+func parseItemIds(value string) []uint {
+	ids := make([]uint, 0)
+	for _, field := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' }) {
+		id, err := strconv.ParseUint(field, 10, 64)
+		if err != nil || id == 0 {
+			continue
+		}
+		ids = append(ids, uint(id))
+	}
+	return ids
+}
+
+// compactJudgements drops the days older than the max age
+func compactJudgements(judgements map[string]*QueryJudgements, now int64) {
+	limit := judgementDay(now) - judgementMaxAge
+	maps.DeleteFunc(judgements, func(query string, value *QueryJudgements) bool {
+		maps.DeleteFunc(value.Searches, func(day int64, count uint) bool {
+			return day < limit
+		})
+		maps.DeleteFunc(value.Items, func(id uint, days map[int64]*JudgementCounts) bool {
+			maps.DeleteFunc(days, func(day int64, counts *JudgementCounts) bool {
+				return day < limit
+			})
+			return len(days) == 0
+		})
+		return len(value.Searches) == 0 && len(value.Items) == 0
+	})
+}
+
+// gradeJudgement turns the counts into a relevance label from 0 to 4, an
+// explicit negative outweighs everything but a purchase
+func gradeJudgement(c JudgementCounts) int {
+	switch {
+	case c.Purchases > 0:
+		return 4
+	case c.Negative > c.Positive:
+		return 0
+	case c.Carts > 0 || c.Positive > 0:
+		return 3
+	case c.Clicks > 0 && (c.Impressions == 0 || float64(c.Clicks)/float64(c.Impressions) >= judgementHighCtr):
+		return 2
+	case c.Clicks > 0:
+		return 1
+	}
+	return 0
+}
+
+// JudgementRequest limits an export to the days overlapping From and To,
+// zero leaves a bound open
+type JudgementRequest struct {
+	From           int64
+	To             int64
+	MinImpressions uint
+}
+
+func (r JudgementRequest) includes(day int64) bool {
+	return day+judgementBucket > r.From && (r.To == 0 || day <= r.To)
+}
+
+type Judgement struct {
+	QueryId      int     `json:"qid"`
+	Query        string  `json:"query"`
+	Item         uint    `json:"item"`
+	Label        int     `json:"label"`
+	Searches     uint    `json:"searches"`
+	Impressions  uint    `json:"impressions"`
+	MeanPosition float64 `json:"mean_position"`
+	Clicks       uint    `json:"clicks"`
+	Carts        uint    `json:"carts"`
+	Purchases    uint    `json:"purchases"`
+	Positive     uint    `json:"positive"`
+	Negative     uint    `json:"negative"`
+}
+
+// GetJudgements grades the items of every query in the range, items shown
+// fewer than MinImpressions times are left out unless someone engaged with
+// them and queries where nothing is relevant are skipped
+func (s *PersistentMemoryTrackingHandler) GetJudgements(request JudgementRequest) []Judgement {
+	s.mu.RLock()
+	queries := make([]string, 0, len(s.Judgements))
+	groups := make(map[string][]Judgement)
+	for query, judgements := range s.Judgements {
+		searches := uint(0)
+		for day, count := range judgements.Searches {
+			if request.includes(day) {
+				searches += count
+			}
+		}
+		group := make([]Judgement, 0)
+		relevant := false
+		for id, days := range judgements.Items {
+			counts := JudgementCounts{}
+			for day, dayCounts := range days {
+				if request.includes(day) {
+					counts.merge(dayCounts)
+				}
+			}
+			if !counts.engaged() && (counts.Impressions == 0 || counts.Impressions < request.MinImpressions) {
+				continue
+			}
+			judgement := Judgement{
+				Query:       query,
+				Item:        id,
+				Label:       gradeJudgement(counts),
+				Searches:    searches,
+				Impressions: counts.Impressions,
+				Clicks:      counts.Clicks,
+				Carts:       counts.Carts,
+				Purchases:   counts.Purchases,
+				Positive:    counts.Positive,
+				Negative:    counts.Negative,
+			}
+			if counts.Impressions > 0 {
+				judgement.MeanPosition = counts.PositionSum / float64(counts.Impressions)
+			}
+			relevant = relevant || judgement.Label > 0
+			group = append(group, judgement)
+		}
+		if !relevant {
+			continue
+		}
+		queries = append(queries, query)
+		groups[query] = group
+	}
+	s.mu.RUnlock()
+
+	slices.Sort(queries)
+	result := make([]Judgement, 0)
+	for i, query := range queries {
+		group := groups[query]
+		slices.SortFunc(group, func(a, b Judgement) int {
+			if c := cmp.Compare(b.Label, a.Label); c != 0 {
+				return c
+			}
+			return cmp.Compare(a.Item, b.Item)
+		})
+		for j := range group {
+			group[j].QueryId = i + 1
+		}
+		result = append(result, group...)
+	}
+	return result
+}
+
+// JudgementEncoder returns the writer for the format, the SVMrank lines carry
+// the mean position and the impressions as features and the item and query as
+// comment so more features can be joined on them. LightGBM does not read qid,
+// it gets a tar with the rows and a group file with the rows per query
+func JudgementEncoder(format string) (func(w io.Writer, judgements []Judgement) error, error) {
+	switch format {
+	case "", JudgementFormatSVMRank:
+		return writeSVMRank, nil
+	case JudgementFormatLightGBM:
+		return writeLightGBM, nil
+	case JudgementFormatJSONL:
+		return writeJudgementsJSONL, nil
+	}
+	return nil, fmt.Errorf("unknown format %s", format)
+}
+
+func writeSVMRank(w io.Writer, judgements []Judgement) error {
+	for _, judgement := range judgements {
+		_, err := fmt.Fprintf(w, "%d qid:%d 1:%g 2:%d # %d %s\n",
+			judgement.Label, judgement.QueryId, judgement.MeanPosition, judgement.Impressions, judgement.Item, judgement.Query)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lightGBMGroups counts the rows of every query, the judgements are ordered
+// by query
+func lightGBMGroups(judgements []Judgement) []int {
+	groups := make([]int, 0)
+	for i, judgement := range judgements {
+		if i == 0 || judgement.QueryId != judgements[i-1].QueryId {
+			groups = append(groups, 0)
+		}
+		groups[len(groups)-1]++
+	}
+	return groups
+}
+
+func writeLightGBM(w io.Writer, judgements []Judgement) error {
+	var data, groups bytes.Buffer
+	for _, judgement := range judgements {
+		fmt.Fprintf(&data, "%d 1:%g 2:%d\n", judgement.Label, judgement.MeanPosition, judgement.Impressions)
+	}
+	for _, count := range lightGBMGroups(judgements) {
+		fmt.Fprintf(&groups, "%d\n", count)
+	}
+	archive := tar.NewWriter(w)
+	for _, file := range []struct {
+		name string
+		data []byte
+	}{{lightGBMDataFile, data.Bytes()}, {lightGBMGroupFile, groups.Bytes()}} {
+		err := archive.WriteHeader(&tar.Header{Name: file.name, Mode: 0644, Size: int64(len(file.data)), ModTime: time.Now()})
+		if err != nil {
+			return err
+		}
+		if _, err = archive.Write(file.data); err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
+
+func writeJudgementsJSONL(w io.Writer, judgements []Judgement) error {
+	encoder := json.NewEncoder(w)
+	for _, judgement := range judgements {
+		if err := encoder.Encode(judgement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
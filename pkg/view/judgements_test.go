@@ -0,0 +1,179 @@
+package view
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matst80/slask-finder/pkg/types"
+)
+
+func TestGradeJudgement(t *testing.T) {
+	tests := []struct {
+		name   string
+		counts JudgementCounts
+		want   int
+	}{
+		{"impression only", JudgementCounts{Impressions: 10}, 0},
+		{"weak click", JudgementCounts{Impressions: 10, Clicks: 1}, 1},
+		{"strong click", JudgementCounts{Impressions: 4, Clicks: 1}, 2},
+		{"cart", JudgementCounts{Impressions: 10, Clicks: 1, Carts: 1}, 3},
+		{"explicit positive", JudgementCounts{Positive: 1}, 3},
+		{"explicit negative", JudgementCounts{Impressions: 2, Clicks: 2, Negative: 1}, 0},
+		{"purchase", JudgementCounts{Impressions: 10, Purchases: 1, Negative: 1}, 4},
+	}
+	for _, tt := range tests {
+		if got := gradeJudgement(tt.counts); got != tt.want {
+			t.Errorf("%s: gradeJudgement(%+v) = %d, want %d", tt.name, tt.counts, got, tt.want)
+		}
+	}
+}
+
+func TestJudgementsAreJoinedPerQuery(t *testing.T) {
+	handler := MakeMemoryTrackingHandler(filepath.Join(t.TempDir(), "tracking.json"), 500)
+	handler.HandleSessionEvent(Session{BaseEvent: &BaseEvent{Event: EVENT_SESSION_START, SessionId: 1}})
+	handler.HandleSearchEvent(SearchEvent{
+		BaseEvent:       &BaseEvent{Event: EVENT_SEARCH, SessionId: 1},
+		Filters:         &types.Filters{},
+		NumberOfResults: 10,
+		Query:           "Samsung TV",
+	}, nil)
+	handler.HandleImpressionEvent(ImpressionEvent{
+		BaseEvent: &BaseEvent{Event: EVENT_ITEM_IMPRESS, SessionId: 1},
+		Items:     []BaseItem{{Id: 1, Position: 0}, {Id: 2, Position: 1}, {Id: 3, Position: 2}, {Id: 6, Position: 3}},
+	}, nil)
+	handler.HandleEvent(Event{
+		BaseEvent: &BaseEvent{Event: EVENT_ITEM_CLICK, SessionId: 1},
+		BaseItem:  &BaseItem{Id: 2, Position: 1},
+	}, nil)
+	handler.HandleCartEvent(CartEvent{
+		BaseEvent: &BaseEvent{Event: CART_ADD, SessionId: 1},
+		BaseItem:  &BaseItem{Id: 3, Quantity: 1},
+	}, nil)
+	handler.HandleDataSetEvent(DataSetEvent{
+		BaseEvent: &BaseEvent{Event: EVENT_DATA_SET, SessionId: 1},
+		Query:     "samsung tv",
+		Negative:  "1, 4",
+	}, nil)
+	// impressions without a search are not judged
+	handler.HandleImpressionEvent(ImpressionEvent{
+		BaseEvent: &BaseEvent{Event: EVENT_ITEM_IMPRESS, SessionId: 2},
+		Items:     []BaseItem{{Id: 5}},
+	}, nil)
+
+	judgements := handler.GetJudgements(JudgementRequest{})
+	labels := make(map[uint]int)
+	for _, judgement := range judgements {
+		if judgement.Query != "samsung tv" || judgement.QueryId != 1 || judgement.Searches != 1 {
+			t.Errorf("Unexpected judgement %+v", judgement)
+		}
+		labels[judgement.Item] = judgement.Label
+	}
+	if len(labels) != 5 || labels[1] != 0 || labels[2] != 2 || labels[3] != 3 || labels[4] != 0 || labels[6] != 0 {
+		t.Errorf("Unexpected labels %v", labels)
+	}
+	if judgements[0].Item != 3 || judgements[1].Item != 2 || judgements[1].MeanPosition != 1 {
+		t.Errorf("Expected the most relevant item first, got %+v", judgements)
+	}
+	if result := handler.GetJudgements(JudgementRequest{MinImpressions: 2}); len(result) != 4 {
+		t.Errorf("Expected the item only shown once to be left out, got %+v", result)
+	}
+	tomorrow := time.Now().Add(24 * time.Hour).Unix()
+	if result := handler.GetJudgements(JudgementRequest{From: tomorrow}); len(result) != 0 {
+		t.Errorf("Expected nothing after the range, got %+v", result)
+	}
+
+	encode, err := JudgementEncoder(JudgementFormatSVMRank)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := encode(&buf, judgements); err != nil {
+		t.Fatal(err)
+	}
+	if line := strings.SplitN(buf.String(), "\n", 2)[0]; line != "3 qid:1 1:2 2:1 # 3 samsung tv" {
+		t.Errorf("Unexpected svmrank line %q", line)
+	}
+	encode, _ = JudgementEncoder(JudgementFormatJSONL)
+	buf.Reset()
+	if err := encode(&buf, judgements); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(buf.String(), "\n"); lines != len(judgements) {
+		t.Errorf("Expected one line per judgement, got %d", lines)
+	}
+	if _, err := JudgementEncoder("csv"); err == nil {
+		t.Errorf("Expected unknown format to be rejected")
+	}
+}
+
+func TestLightGBMGroupsMatchRows(t *testing.T) {
+	judgements := []Judgement{
+		{QueryId: 1, Label: 3, Impressions: 4, MeanPosition: 1},
+		{QueryId: 1, Label: 0, Impressions: 2},
+		{QueryId: 2, Label: 1, Impressions: 1},
+		{QueryId: 3, Label: 2, Impressions: 5},
+		{QueryId: 3, Label: 0, Impressions: 5},
+		{QueryId: 3, Label: 0, Impressions: 5},
+	}
+	encode, err := JudgementEncoder(JudgementFormatLightGBM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := encode(&buf, judgements); err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string)
+	archive := tar.NewReader(&buf)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(archive)
+		files[header.Name] = string(data)
+	}
+	rows := strings.Split(strings.TrimSpace(files[lightGBMDataFile]), "\n")
+	if len(rows) != len(judgements) || rows[0] != "3 1:1 2:4" {
+		t.Errorf("Unexpected rows %q", rows)
+	}
+	total := 0
+	for _, line := range strings.Fields(files[lightGBMGroupFile]) {
+		count, err := strconv.Atoi(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		total += count
+	}
+	if files[lightGBMGroupFile] != "2\n1\n3\n" || total != len(rows) {
+		t.Errorf("Expected group counts adding up to %d rows, got %q", len(rows), files[lightGBMGroupFile])
+	}
+}
+
+func TestCompactJudgements(t *testing.T) {
+	now := time.Now().Unix()
+	old := judgementDay(now) - judgementMaxAge - judgementBucket
+	judgements := map[string]*QueryJudgements{
+		"tv": {
+			Searches: map[int64]uint{old: 1, judgementDay(now): 1},
+			Items:    map[uint]map[int64]*JudgementCounts{1: {old: {Clicks: 1}}},
+		},
+		"radio": {
+			Searches: map[int64]uint{old: 1},
+			Items:    map[uint]map[int64]*JudgementCounts{},
+		},
+	}
+	compactJudgements(judgements, now)
+	if len(judgements) != 1 || len(judgements["tv"].Searches) != 1 || len(judgements["tv"].Items) != 0 {
+		t.Errorf("Expected old days to be dropped, got %+v", judgements)
+	}
+}
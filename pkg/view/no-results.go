@@ -0,0 +1,229 @@
+package view
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	ProfileNoResults = "no-results"
+	// sessions and filter values kept per query, the distinct session count
+	// stops growing at the cap
+	noResultMaxSessions     = 1000
+	noResultMaxFilterValues = 20
+	noResultsPageSize       = 50
+	noResultsMaxPageSize    = 500
+)
+
+const (
+	NoResultsSortFrequency = "frequency"
+	NoResultsSortCount     = "count"
+	NoResultsSortSessions  = "sessions"
+	NoResultsSortFirstSeen = "first_seen"
+	NoResultsSortLastSeen  = "last_seen"
+	NoResultsSortQuery     = "query"
+)
+
+type NoResultFilter struct {
+	Count  uint            `json:"count"`
+	Values map[string]uint `json:"values,omitempty"`
+}
+
+// NoResultQuery aggregates the searches for a normalized query that returned
+// nothing
+type NoResultQuery struct {
+	Frequency DecayValue               `json:"frequency"`
+	Count     uint                     `json:"count"`
+	FirstSeen int64                    `json:"first_seen"`
+	LastSeen  int64                    `json:"last_seen"`
+	Sessions  map[int64]uint           `json:"sessions"`
+	Filters   map[uint]*NoResultFilter `json:"filters"`
+}
+
+func NewNoResultQuery() *NoResultQuery {
+	return &NoResultQuery{
+		Sessions: make(map[int64]uint),
+		Filters:  make(map[uint]*NoResultFilter),
+	}
+}
+
+func (q *NoResultQuery) addFilter(id uint, value string) {
+	filter, ok := q.Filters[id]
+	if !ok {
+		filter = &NoResultFilter{Values: make(map[string]uint)}
+		q.Filters[id] = filter
+	}
+	filter.Count++
+	if value == "" {
+		return
+	}
+	if _, ok := filter.Values[value]; ok || len(filter.Values) < noResultMaxFilterValues {
+		filter.Values[value]++
+	}
+}
+
+func (q *NoResultQuery) Add(event SearchEvent, ts int64) {
+	if q.Sessions == nil {
+		q.Sessions = make(map[int64]uint)
+	}
+	if q.Filters == nil {
+		q.Filters = make(map[uint]*NoResultFilter)
+	}
+	q.Frequency.Add(GetDecayProfile(ProfileNoResults), DecayEvent{TimeStamp: ts, Value: 1})
+	q.Count++
+	if q.FirstSeen == 0 || ts < q.FirstSeen {
+		q.FirstSeen = ts
+	}
+	q.LastSeen = max(q.LastSeen, ts)
+	if event.BaseEvent != nil && event.SessionId != 0 {
+		if _, ok := q.Sessions[event.SessionId]; ok || len(q.Sessions) < noResultMaxSessions {
+			q.Sessions[event.SessionId]++
+		}
+	}
+	if event.Filters == nil {
+		return
+	}
+	for _, filter := range event.Filters.StringFilter {
+		for _, value := range filter.Value {
+			q.addFilter(filter.Id, value)
+		}
+	}
+	for _, filter := range event.Filters.RangeFilter {
+		q.addFilter(filter.Id, "")
+	}
+}
+
+// addNoResult stores a search without results under its normalized query
+func addNoResult(noResults map[string]*NoResultQuery, query string, event SearchEvent, ts int64) {
+	if query == "" {
+		return
+	}
+	if event.BaseEvent != nil && event.TimeStamp > 0 {
+		ts = event.TimeStamp
+	}
+	noResult, ok := noResults[query]
+	if !ok {
+		noResult = NewNoResultQuery()
+		noResults[query] = noResult
+	}
+	noResult.Add(event, ts)
+}
+
+// compactNoResults drops the queries nobody has searched for within the max
+// age of the profile
+func compactNoResults(noResults map[string]*NoResultQuery, now int64) {
+	profile := GetDecayProfile(ProfileNoResults)
+	maps.DeleteFunc(noResults, func(query string, value *NoResultQuery) bool {
+		return value.Frequency.ValueAt(profile, now) < 0.0002
+	})
+}
+
+type NoResultFilterResult struct {
+	Id     uint            `json:"id"`
+	Count  uint            `json:"count"`
+	Values map[string]uint `json:"values,omitempty"`
+}
+
+type NoResultResult struct {
+	Query     string                 `json:"query"`
+	Frequency float64                `json:"frequency"`
+	Count     uint                   `json:"count"`
+	FirstSeen int64                  `json:"first_seen"`
+	LastSeen  int64                  `json:"last_seen"`
+	Sessions  int                    `json:"sessions"`
+	Filters   []NoResultFilterResult `json:"filters"`
+}
+
+type NoResultsPage struct {
+	Total int              `json:"total"`
+	Page  int              `json:"page"`
+	Size  int              `json:"size"`
+	Items []NoResultResult `json:"items"`
+}
+
+// NoResultsRequest selects a page of no result queries, sorted descending on
+// the sort field unless Ascending is set
+type NoResultsRequest struct {
+	Sort      string
+	Ascending bool
+	Page      int
+	Size      int
+}
+
+func noResultsCompare(sort string) (func(a, b NoResultResult) int, error) {
+	switch sort {
+	case "", NoResultsSortFrequency:
+		return func(a, b NoResultResult) int { return cmp.Compare(a.Frequency, b.Frequency) }, nil
+	case NoResultsSortCount:
+		return func(a, b NoResultResult) int { return cmp.Compare(a.Count, b.Count) }, nil
+	case NoResultsSortSessions:
+		return func(a, b NoResultResult) int { return cmp.Compare(a.Sessions, b.Sessions) }, nil
+	case NoResultsSortFirstSeen:
+		return func(a, b NoResultResult) int { return cmp.Compare(a.FirstSeen, b.FirstSeen) }, nil
+	case NoResultsSortLastSeen:
+		return func(a, b NoResultResult) int { return cmp.Compare(a.LastSeen, b.LastSeen) }, nil
+	case NoResultsSortQuery:
+		return func(a, b NoResultResult) int { return strings.Compare(a.Query, b.Query) }, nil
+	}
+	return nil, fmt.Errorf("unknown sort %s", sort)
+}
+
+func (s *PersistentMemoryTrackingHandler) GetNoResultQueries(request NoResultsRequest) (NoResultsPage, error) {
+	compare, err := noResultsCompare(request.Sort)
+	if err != nil {
+		return NoResultsPage{}, err
+	}
+	if request.Size <= 0 {
+		request.Size = noResultsPageSize
+	}
+	request.Size = min(request.Size, noResultsMaxPageSize)
+	request.Page = max(request.Page, 0)
+
+	s.mu.RLock()
+	now := time.Now().Unix()
+	profile := GetDecayProfile(ProfileNoResults)
+	items := make([]NoResultResult, 0, len(s.NoResults))
+	for query, noResult := range s.NoResults {
+		filters := make([]NoResultFilterResult, 0, len(noResult.Filters))
+		for id, filter := range noResult.Filters {
+			filters = append(filters, NoResultFilterResult{Id: id, Count: filter.Count, Values: maps.Clone(filter.Values)})
+		}
+		slices.SortFunc(filters, func(a, b NoResultFilterResult) int {
+			return cmp.Compare(b.Count, a.Count)
+		})
+		items = append(items, NoResultResult{
+			Query:     query,
+			Frequency: noResult.Frequency.ValueAt(profile, now),
+			Count:     noResult.Count,
+			FirstSeen: noResult.FirstSeen,
+			LastSeen:  noResult.LastSeen,
+			Sessions:  len(noResult.Sessions),
+			Filters:   filters,
+		})
+	}
+	s.mu.RUnlock()
+
+	slices.SortFunc(items, func(a, b NoResultResult) int {
+		c := compare(a, b)
+		if !request.Ascending {
+			c = -c
+		}
+		if c == 0 {
+			// the query breaks ties so pages do not overlap
+			return strings.Compare(a.Query, b.Query)
+		}
+		return c
+	})
+	start := min(request.Page*request.Size, len(items))
+	end := min(start+request.Size, len(items))
+	return NoResultsPage{
+		Total: len(items),
+		Page:  request.Page,
+		Size:  request.Size,
+		Items: items[start:end],
+	}, nil
+}
@@ -0,0 +1,70 @@
+package view
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/matst80/slask-finder/pkg/types"
+)
+
+func TestNoResultsAreAggregated(t *testing.T) {
+	handler := MakeMemoryTrackingHandler(filepath.Join(t.TempDir(), "tracking.json"), 500)
+	search := func(session int64, ts int64, query string, filters *types.Filters) {
+		handler.HandleSearchEvent(SearchEvent{
+			BaseEvent: &BaseEvent{Event: EVENT_SEARCH, SessionId: session, TimeStamp: ts},
+			Filters:   filters,
+			Query:     query,
+		}, nil)
+	}
+	search(1, 1000, "Väggfäste", &types.Filters{StringFilter: []types.StringFilter{{Id: 10, Value: []string{"Samsung"}}}})
+	search(1, 2000, "väggfäste ", &types.Filters{RangeFilter: []types.RangeFilter{{Id: 4, Min: 1, Max: 2}}})
+	search(2, 1500, "VÄGGFÄSTE", nil)
+	search(3, 1200, "", nil)
+
+	noResult, ok := handler.NoResults["väggfäste"]
+	if !ok || len(handler.NoResults) != 1 {
+		t.Fatalf("Expected one aggregated query, got %+v", handler.NoResults)
+	}
+	if noResult.Count != 3 || len(noResult.Sessions) != 2 || noResult.FirstSeen != 1000 || noResult.LastSeen != 2000 {
+		t.Errorf("Unexpected aggregate %+v", noResult)
+	}
+	if noResult.Filters[10] == nil || noResult.Filters[10].Values["Samsung"] != 1 || noResult.Filters[4] == nil || noResult.Filters[4].Count != 1 {
+		t.Errorf("Expected used filters to be counted, got %+v", noResult.Filters)
+	}
+}
+
+func TestGetNoResultQueriesSortsAndPages(t *testing.T) {
+	handler := MakeMemoryTrackingHandler(filepath.Join(t.TempDir(), "tracking.json"), 500)
+	for i := range 5 {
+		for range i + 1 {
+			handler.HandleSearchEvent(SearchEvent{
+				BaseEvent: &BaseEvent{Event: EVENT_SEARCH, SessionId: int64(i + 1)},
+				Query:     fmt.Sprintf("missing %c", 'a'+i),
+			}, nil)
+		}
+	}
+
+	page, err := handler.GetNoResultQueries(NoResultsRequest{Sort: NoResultsSortCount, Size: 2})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if page.Total != 5 || len(page.Items) != 2 || page.Items[0].Query != "missing e" || page.Items[1].Query != "missing d" {
+		t.Errorf("Expected most frequent queries first, got %+v", page)
+	}
+	page, _ = handler.GetNoResultQueries(NoResultsRequest{Sort: NoResultsSortCount, Size: 2, Page: 2})
+	if len(page.Items) != 1 || page.Items[0].Query != "missing a" {
+		t.Errorf("Expected last page to hold the remaining query, got %+v", page)
+	}
+	page, _ = handler.GetNoResultQueries(NoResultsRequest{Sort: NoResultsSortQuery, Ascending: true})
+	if len(page.Items) != 5 || page.Items[0].Query != "missing a" || page.Size != noResultsPageSize {
+		t.Errorf("Expected queries in alphabetical order, got %+v", page)
+	}
+	page, _ = handler.GetNoResultQueries(NoResultsRequest{Page: 10})
+	if len(page.Items) != 0 || page.Total != 5 {
+		t.Errorf("Expected empty page past the end, got %+v", page)
+	}
+	if _, err = handler.GetNoResultQueries(NoResultsRequest{Sort: "price"}); err == nil {
+		t.Errorf("Expected unknown sort to be rejected")
+	}
+}
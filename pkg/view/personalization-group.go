@@ -2,7 +2,6 @@ package view
 
 import (
 	"log"
-	"time"
 )
 
 type PersonalizationGroup struct {
@@ -15,26 +14,22 @@ type PersonalizationGroup struct {
 	LastSync    int64     `json:"last_sync"`
 }
 
-func (p *PersonalizationGroup) HandleEvent(event interface{}) {
-	now := time.Now().Unix()
-	if p.FieldEvents == nil {
-		p.FieldEvents = make(DecayList)
-	}
-	if p.ItemEvents == nil {
-		p.ItemEvents = make(DecayList)
-	}
+func (p *PersonalizationGroup) HandleEvent(event interface{}, now int64) {
+	p.FieldEvents.Bind(ProfileField)
+	p.ItemEvents.Bind(ProfileItem)
 	if p.Created == 0 {
 		p.Created = now
 	}
 	if p.LastUpdate == 0 {
 		p.LastUpdate = now
 	}
+	weights := GetEventWeights().Group
 	switch e := event.(type) {
 	case Event:
 		if e.BaseItem != nil && e.Id > 0 {
 			p.ItemEvents.Add(e.Id, DecayEvent{
 				TimeStamp: now,
-				Value:     200,
+				Value:     weights.Click.Value(e.Quantity, e.Position),
 			})
 
 		} else {
@@ -45,13 +40,13 @@ func (p *PersonalizationGroup) HandleEvent(event interface{}) {
 		for _, filter := range e.Filters.StringFilter {
 			p.FieldEvents.Add(filter.Id, DecayEvent{
 				TimeStamp: now,
-				Value:     150,
+				Value:     weights.Filter.Value(0, 0),
 			})
 		}
 		for _, filter := range e.Filters.RangeFilter {
 			p.FieldEvents.Add(filter.Id, DecayEvent{
 				TimeStamp: now,
-				Value:     100,
+				Value:     weights.RangeFilter.Value(0, 0),
 			})
 		}
 
@@ -59,21 +54,23 @@ func (p *PersonalizationGroup) HandleEvent(event interface{}) {
 		for _, impression := range e.Items {
 			p.ItemEvents.Add(impression.Id, DecayEvent{
 				TimeStamp: now,
-				Value:     0.02 * float64(max(impression.Position, 300)),
+				Value:     weights.Impression.Value(impression.Quantity, impression.Position),
 			})
 		}
 
 	case CartEvent:
-		p.ItemEvents.Add(e.Id, DecayEvent{
-			TimeStamp: now,
-			Value:     700,
-		})
+		if e.BaseItem != nil && e.Id > 0 {
+			p.ItemEvents.Add(e.Id, DecayEvent{
+				TimeStamp: now,
+				Value:     weights.Cart.Value(e.Quantity, e.Position),
+			})
+		}
 
 	case ActionEvent:
 		if e.BaseItem != nil && e.Id > 0 {
 			p.ItemEvents.Add(e.Id, DecayEvent{
 				TimeStamp: now,
-				Value:     80,
+				Value:     weights.Action.Value(e.Quantity, e.Position),
 			})
 		}
 
@@ -81,7 +78,7 @@ func (p *PersonalizationGroup) HandleEvent(event interface{}) {
 		for _, purchase := range e.Items {
 			p.ItemEvents.Add(purchase.Id, DecayEvent{
 				TimeStamp: now,
-				Value:     800 * float64(purchase.Quantity),
+				Value:     weights.Purchase.Value(purchase.Quantity, purchase.Position),
 			})
 		}
 
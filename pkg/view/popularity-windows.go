@@ -0,0 +1,199 @@
+package view
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"time"
+
+	"github.com/matst80/slask-finder/pkg/sorting"
+)
+
+type popularityWindow struct {
+	Name     string
+	HalfLife time.Duration
+}
+
+// the windows are named after their length but are not sliding windows, the
+// popularity in a window decays with a half life of the window length and
+// events are forgotten after four half lives, 24h is a 24 hour half life
+var popularityWindows = []popularityWindow{
+	{Name: "1h", HalfLife: time.Hour},
+	{Name: "24h", HalfLife: time.Hour * 24},
+	{Name: "7d", HalfLife: time.Hour * 24 * 7},
+	{Name: "30d", HalfLife: time.Hour * 24 * 30},
+}
+
+var ErrUnknownWindow = errors.New("unknown popularity window")
+
+func windowProfileName(window string) string {
+	return "window-" + window
+}
+
+func windowProfiles() map[string]*DecayProfile {
+	result := make(map[string]*DecayProfile, len(popularityWindows))
+	for _, window := range popularityWindows {
+		halfLife := int64(window.HalfLife.Seconds())
+		profile, err := NewDecayProfile(windowProfileName(window.Name), halfLife, halfLife*4)
+		if err != nil {
+			panic(err)
+		}
+		result[profile.Name] = profile
+	}
+	return result
+}
+
+type PopularityWindow struct {
+	Items   DecayList                   `json:"items"`
+	Fields  DecayList                   `json:"fields"`
+	Queries map[string]*DecayPopularity `json:"queries"`
+}
+
+func NewPopularityWindow(name string) *PopularityWindow {
+	profile := windowProfileName(name)
+	return &PopularityWindow{
+		Items:   NewDecayList(profile),
+		Fields:  NewDecayList(profile),
+		Queries: make(map[string]*DecayPopularity),
+	}
+}
+
+func (w *PopularityWindow) bind(name string) {
+	profile := windowProfileName(name)
+	w.Items.Bind(profile)
+	w.Fields.Bind(profile)
+	if w.Queries == nil {
+		w.Queries = make(map[string]*DecayPopularity)
+	}
+	for _, popularity := range w.Queries {
+		popularity.Profile = profile
+	}
+}
+
+func (w *PopularityWindow) addQuery(name string, query string, event DecayEvent) {
+	popularity, ok := w.Queries[query]
+	if !ok {
+		popularity = NewDecayPopularity(windowProfileName(name))
+		w.Queries[query] = popularity
+	}
+	popularity.Add(event)
+}
+
+func (w *PopularityWindow) queryPopularity(now int64) map[string]float64 {
+	result := make(map[string]float64, len(w.Queries))
+	for query, popularity := range w.Queries {
+		value := popularity.ValueAt(GetDecayProfile(popularity.Profile), now)
+		if value < 0.002 {
+			continue
+		}
+		result[query] = value
+	}
+	return result
+}
+
+func (w *PopularityWindow) compact(now int64) {
+	w.Items.Compact(now)
+	w.Fields.Compact(now)
+	maps.DeleteFunc(w.Queries, func(key string, value *DecayPopularity) bool {
+		return value.ValueAt(GetDecayProfile(value.Profile), now) < 0.0002
+	})
+}
+
+// ensureWindows creates the windows missing from a loaded snapshot
+func (s *PersistentMemoryTrackingHandler) ensureWindows() {
+	if s.Windows == nil {
+		s.Windows = make(map[string]*PopularityWindow)
+	}
+	for _, window := range popularityWindows {
+		w, ok := s.Windows[window.Name]
+		if !ok || w == nil {
+			s.Windows[window.Name] = NewPopularityWindow(window.Name)
+			continue
+		}
+		w.bind(window.Name)
+	}
+}
+
+func (s *PersistentMemoryTrackingHandler) addItemEvent(id uint, event DecayEvent) {
+	s.ItemEvents.Add(id, event)
+	for _, w := range s.Windows {
+		w.Items.Add(id, event)
+	}
+}
+
+func (s *PersistentMemoryTrackingHandler) addFieldEvent(id uint, event DecayEvent) {
+	s.FieldEvents.Add(id, event)
+	for _, w := range s.Windows {
+		w.Fields.Add(id, event)
+	}
+}
+
+func (s *PersistentMemoryTrackingHandler) addQueryEvent(query string, event DecayEvent) {
+	for name, w := range s.Windows {
+		w.addQuery(name, query, event)
+	}
+}
+
+func (s *PersistentMemoryTrackingHandler) getWindow(name string) (*PopularityWindow, error) {
+	w, ok := s.Windows[name]
+	if !ok {
+		return nil, fmt.Errorf("%w %s", ErrUnknownWindow, name)
+	}
+	return w, nil
+}
+
+func (s *PersistentMemoryTrackingHandler) GetWindowItemPopularity(name string) (sorting.SortOverride, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	w, err := s.getWindow(name)
+	if err != nil {
+		return nil, err
+	}
+	return w.Items.Decay(time.Now().Unix()), nil
+}
+
+func (s *PersistentMemoryTrackingHandler) GetWindowFieldPopularity(name string) (sorting.SortOverride, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	w, err := s.getWindow(name)
+	if err != nil {
+		return nil, err
+	}
+	return w.Fields.Decay(time.Now().Unix()), nil
+}
+
+func (s *PersistentMemoryTrackingHandler) GetWindowQueryPopularity(name string) (map[string]float64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	w, err := s.getWindow(name)
+	if err != nil {
+		return nil, err
+	}
+	return w.queryPopularity(time.Now().Unix()), nil
+}
+
+// DecayWindows drops decayed values from the windows and publishes the item
+// and field popularity of every window as popular-{window} and
+// popular-fields-{window}
+func (s *PersistentMemoryTrackingHandler) DecayWindows() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().Unix()
+	for name, w := range s.Windows {
+		w.compact(now)
+		if s.trackingHandler == nil {
+			continue
+		}
+		items := w.Items.Decay(now)
+		fields := w.Fields.Decay(now)
+		go func() {
+			if err := s.trackingHandler.SortOverrideChanged("popular-"+name, &items); err != nil {
+				log.Printf("Failed to publish popularity for window %s: %v", name, err)
+			}
+			if err := s.trackingHandler.FieldSortOverrideChanged("popular-fields-"+name, &fields); err != nil {
+				log.Printf("Failed to publish field popularity for window %s: %v", name, err)
+			}
+		}()
+	}
+}
@@ -0,0 +1,47 @@
+package view
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestPopularityWindows(t *testing.T) {
+	handler := MakeMemoryTrackingHandler(filepath.Join(t.TempDir(), "tracking.json"), 500)
+	if len(handler.Windows) != len(popularityWindows) {
+		t.Fatalf("Expected %d windows, got %d", len(popularityWindows), len(handler.Windows))
+	}
+	handler.HandleEvent(Event{
+		BaseEvent: &BaseEvent{Event: EVENT_ITEM_CLICK, SessionId: 1},
+		BaseItem:  &BaseItem{Id: 7},
+	}, nil)
+
+	for _, window := range popularityWindows {
+		popularity, err := handler.GetWindowItemPopularity(window.Name)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if popularity[7] < 199 {
+			t.Errorf("Expected click in window %s, got %v", window.Name, popularity[7])
+		}
+	}
+	if _, err := handler.GetWindowItemPopularity("2h"); !errors.Is(err, ErrUnknownWindow) {
+		t.Errorf("Expected unknown window error, got %v", err)
+	}
+}
+
+func TestWindowDecaysWithItsHalfLife(t *testing.T) {
+	w := NewPopularityWindow("1h")
+	w.Items.Add(1, DecayEvent{TimeStamp: 0, Value: 100})
+	w.addQuery("1h", "tv", DecayEvent{TimeStamp: 0, Value: 100})
+	if got := w.Items.Decay(3600)[1]; got < 49.99 || got > 50.01 {
+		t.Errorf("Expected half the value after one hour, got %v", got)
+	}
+	if got := w.queryPopularity(3600)["tv"]; got < 49.99 || got > 50.01 {
+		t.Errorf("Expected half the query popularity after one hour, got %v", got)
+	}
+	w.compact(3600 * 5)
+	if w.Items.Len() != 0 || len(w.Queries) != 0 {
+		t.Errorf("Expected expired values to be compacted")
+	}
+}
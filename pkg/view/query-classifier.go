@@ -0,0 +1,166 @@
+package view
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	JunkIdList   = "id_list"
+	JunkNegation = "negation"
+	JunkWildcard = "wildcard"
+	JunkTooLong  = "too_long"
+	// human queries are shorter than this, in letters and in words
+	maxQueryLength = 80
+	maxQueryWords  = 12
+	// an id list has at least two words and most of them look like ids
+	idListMinWords = 2
+	idListRatio    = 0.8
+	idMinLength    = 5
+	// queries kept for debugging, once full only the totals per kind grow
+	maxJunkQueries = 10000
+)
+
+// isIdLike matches item ids and article numbers, words with a digit and only
+// letters and digits
+func isIdLike(word string) bool {
+	if len([]rune(word)) < idMinLength {
+		return false
+	}
+	digit := false
+	for _, r := range word {
+		if unicode.IsDigit(r) {
+			digit = true
+		} else if !unicode.IsLetter(r) {
+			return false
+		}
+	}
+	return digit
+}
+
+// ClassifyQuery returns the kind of junk a machine generated query is, or an
+// empty string for queries typed by a person
+func ClassifyQuery(query string) string {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return ""
+	}
+	if strings.Trim(query, "*") == "" {
+		return JunkWildcard
+	}
+	if strings.HasPrefix(query, "!") || strings.Contains(query, " !") || strings.Contains(query, "_!") {
+		return JunkNegation
+	}
+	words := strings.Fields(query)
+	if len([]rune(query)) > maxQueryLength || len(words) > maxQueryWords {
+		return JunkTooLong
+	}
+	if len(words) >= idListMinWords {
+		ids := 0
+		for _, word := range words {
+			if isIdLike(word) {
+				ids++
+			}
+		}
+		if float64(ids) >= float64(len(words))*idListRatio {
+			return JunkIdList
+		}
+	}
+	return ""
+}
+
+// classifyQuery also checks the normalized query, which no longer has the
+// punctuation that can hide an id list
+func classifyQuery(query string, normalized string) string {
+	if kind := ClassifyQuery(query); kind != "" {
+		return kind
+	}
+	return ClassifyQuery(normalized)
+}
+
+type JunkQuery struct {
+	Kind     string `json:"kind"`
+	Count    uint   `json:"count"`
+	LastSeen int64  `json:"last_seen"`
+}
+
+type JunkQueryResult struct {
+	Query string `json:"query"`
+	JunkQuery
+}
+
+type JunkQueries struct {
+	Totals  map[string]uint   `json:"totals"`
+	Queries []JunkQueryResult `json:"queries"`
+}
+
+func (s *PersistentMemoryTrackingHandler) addJunkQuery(query string, kind string, count uint, ts int64) {
+	if s.JunkTotals == nil {
+		s.JunkTotals = make(map[string]uint)
+	}
+	if s.JunkQueries == nil {
+		s.JunkQueries = make(map[string]*JunkQuery)
+	}
+	s.JunkTotals[kind] += count
+	junk, ok := s.JunkQueries[query]
+	if !ok {
+		if len(s.JunkQueries) >= maxJunkQueries {
+			return
+		}
+		junk = &JunkQuery{Kind: kind}
+		s.JunkQueries[query] = junk
+	}
+	junk.Count += count
+	junk.LastSeen = max(junk.LastSeen, ts)
+}
+
+// separateJunkQueries moves junk stored before the classifier existed, or
+// matched by changed rules, out of the query store
+func (s *PersistentMemoryTrackingHandler) separateJunkQueries() {
+	ts := time.Now().Unix()
+	for query, count := range s.Queries {
+		if kind := ClassifyQuery(query); kind != "" {
+			s.addJunkQuery(query, kind, count, ts)
+			delete(s.Queries, query)
+		}
+	}
+	for query := range s.QueryEvents {
+		if ClassifyQuery(query) != "" {
+			delete(s.QueryEvents, query)
+		}
+	}
+	s.SortedQueries = slices.DeleteFunc(s.SortedQueries, func(result QueryResult) bool {
+		return ClassifyQuery(result.Query) != ""
+	})
+	for query := range s.NoResults {
+		if ClassifyQuery(query) != "" {
+			delete(s.NoResults, query)
+		}
+	}
+}
+
+// GetJunkQueries returns the totals per kind and the most frequent junk queries
+func (s *PersistentMemoryTrackingHandler) GetJunkQueries() JunkQueries {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := JunkQueries{
+		Totals:  make(map[string]uint, len(s.JunkTotals)),
+		Queries: make([]JunkQueryResult, 0, len(s.JunkQueries)),
+	}
+	for kind, count := range s.JunkTotals {
+		result.Totals[kind] = count
+	}
+	for query, junk := range s.JunkQueries {
+		result.Queries = append(result.Queries, JunkQueryResult{Query: query, JunkQuery: *junk})
+	}
+	slices.SortFunc(result.Queries, func(a, b JunkQueryResult) int {
+		if c := cmp.Compare(b.Count, a.Count); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Query, b.Query)
+	})
+	return result
+}
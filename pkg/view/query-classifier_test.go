@@ -0,0 +1,93 @@
+package view
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/matst80/slask-finder/pkg/types"
+)
+
+func TestClassifyQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"samsung tv", ""},
+		{"macbook pro 16 m4 ", ""},
+		{"rtx 4070 4080", ""},
+		{"626885", ""},
+		{"iphone 15 128gb", ""},
+		{"*", JunkWildcard},
+		{"**", JunkWildcard},
+		{"!305399___!305400___!305406", JunkNegation},
+		{"tv !samsung", JunkNegation},
+		{"626885 626886", JunkIdList},
+		{"748310 748311 684183 684182 506256 506257", JunkIdList},
+		{"TE655319RW 252555 252559 L40PFW17E 361910", JunkIdList},
+		{strings.Repeat("tv ", 13), JunkTooLong},
+		{strings.Repeat("a", maxQueryLength+1), JunkTooLong},
+	}
+	for _, tt := range tests {
+		if got := ClassifyQuery(tt.query); got != tt.want {
+			t.Errorf("ClassifyQuery(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestJunkQueriesAreKeptOutOfQueryStore(t *testing.T) {
+	handler := MakeMemoryTrackingHandler(filepath.Join(t.TempDir(), "tracking.json"), 500)
+	search := func(query string, results int) {
+		handler.HandleSearchEvent(SearchEvent{
+			BaseEvent:       &BaseEvent{Event: EVENT_SEARCH, SessionId: 1},
+			Filters:         &types.Filters{},
+			NumberOfResults: results,
+			Query:           query,
+		}, nil)
+	}
+	search("626885 626886", 2)
+	search("626885 626886", 2)
+	search("626885, 626886", 2)
+	search("!305399___!305400", 0)
+	search("tvbenk", 5)
+	handler.HandleSuggestEvent(SuggestEvent{
+		BaseEvent: &BaseEvent{Event: EVENT_SUGGEST, SessionId: 1},
+		Value:     "*",
+	}, nil)
+
+	if len(handler.QueryEvents) != 1 || len(handler.Queries) != 1 || handler.Queries["tvbenk"] != 1 {
+		t.Errorf("Expected only the real query to be stored, got %v", handler.Queries)
+	}
+	if len(handler.NoResults) != 0 {
+		t.Errorf("Expected junk to be kept out of no result queries, got %+v", handler.NoResults)
+	}
+	junk := handler.GetJunkQueries()
+	if junk.Totals[JunkIdList] != 3 || junk.Totals[JunkNegation] != 1 || junk.Totals[JunkWildcard] != 1 {
+		t.Errorf("Unexpected junk totals %v", junk.Totals)
+	}
+	if len(junk.Queries) != 4 || junk.Queries[0].Query != "626885 626886" || junk.Queries[0].Count != 2 {
+		t.Errorf("Expected most frequent junk first, got %+v", junk.Queries)
+	}
+}
+
+func TestLoadSeparatesJunkQueries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tracking.json")
+	snapshot := `{"version":2,"queries":{"tv":2,"377621 26172 361910":34},
+		"suggestions":{"tv":{"popularity":{"value":1}},"!305399___!305400":{"popularity":{"value":1}}},
+		"sorted_queries":[{"query":"!305399___!305400","score":1},{"query":"tv","score":1}]}`
+	if err := os.WriteFile(path, []byte(snapshot), 0644); err != nil {
+		t.Fatal(err)
+	}
+	handler := MakeMemoryTrackingHandler(path, 500)
+
+	if len(handler.Queries) != 1 || len(handler.QueryEvents) != 1 || len(handler.SortedQueries) != 1 {
+		t.Errorf("Expected junk to be removed, got %v %v %v", handler.Queries, handler.QueryEvents, handler.SortedQueries)
+	}
+	if junk := handler.JunkQueries["377621 26172 361910"]; junk == nil || junk.Count != 34 || junk.Kind != JunkIdList {
+		t.Errorf("Expected stored count to move to junk queries, got %+v", junk)
+	}
+	if result := handler.GetSuggestions("!30", "", 10); len(result) != 0 {
+		t.Errorf("Expected no junk suggestions, got %+v", result)
+	}
+}
@@ -0,0 +1,148 @@
+package view
+
+import (
+	"cmp"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/matst80/slask-finder/pkg/sorting"
+)
+
+const (
+	ProfileQueryItems = "query-items"
+	// clicks, carts and purchases belong to the latest search in the session
+	// when it is at most this many seconds old
+	queryAttributionWindow = 3600
+	// at most this many changed queries are published per decay, the ones
+	// with the most engagement first
+	queryItemsPublishLimit = 100
+	// queries below about one click are not published
+	queryItemsMinScore = 200
+)
+
+func queryOverrideKey(query string) string {
+	return "query-" + query
+}
+
+// leaveSearch ends the latest search when the session shows a listing from
+// another context, like a category page or a widget, so the events that
+// follow are not tied to the search. Contexts are only compared when both the
+// search and the event have one
+func leaveSearch(session *SessionData, context string) {
+	if session == nil || session.LastSearch == nil {
+		return
+	}
+	if context != "" && session.LastSearch.Context != "" && context != session.LastSearch.Context {
+		session.LastSearch = nil
+	}
+}
+
+// attributeToQuery adds the item event to the items of the latest search in
+// the session
+func (s *PersistentMemoryTrackingHandler) attributeToQuery(session *SessionData, id uint, value float64, ts int64) {
+	if session == nil || session.LastSearch == nil || id == 0 || value <= 0 {
+		return
+	}
+	search := session.LastSearch
+	if ts-search.TimeStamp > queryAttributionWindow {
+		return
+	}
+	if s.QueryItems == nil {
+		s.QueryItems = make(map[string]*DecayList)
+	}
+	items, ok := s.QueryItems[search.Query]
+	if !ok {
+		list := NewDecayList(ProfileQueryItems)
+		items = &list
+		s.QueryItems[search.Query] = items
+	}
+	items.Add(id, DecayEvent{TimeStamp: ts, Value: value})
+	if s.changedQueryItems == nil {
+		s.changedQueryItems = make(map[string]struct{})
+	}
+	s.changedQueryItems[search.Query] = struct{}{}
+}
+
+func (s *PersistentMemoryTrackingHandler) bindQueryItems() {
+	if s.QueryItems == nil {
+		s.QueryItems = make(map[string]*DecayList)
+	}
+	for query, items := range s.QueryItems {
+		if items == nil {
+			delete(s.QueryItems, query)
+			continue
+		}
+		items.Bind(ProfileQueryItems)
+	}
+}
+
+// GetQueryItems returns the decayed score of the items found through the
+// normalized query
+func (s *PersistentMemoryTrackingHandler) GetQueryItems(query string) sorting.SortOverride {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	items, ok := s.QueryItems[query]
+	if !ok {
+		return sorting.SortOverride{}
+	}
+	return items.Decay(time.Now().Unix())
+}
+
+type queryItemsUpdate struct {
+	key   string
+	score float64
+	sort  sorting.SortOverride
+}
+
+// DecayQueryItems drops decayed items and queries and publishes the items of
+// the most engaged queries changed since the last save as query-{query}, the
+// updates are sent one after another from a single goroutine
+func (s *PersistentMemoryTrackingHandler) DecayQueryItems() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().Unix()
+	for query, items := range s.QueryItems {
+		items.Compact(now)
+		if items.Len() == 0 {
+			delete(s.QueryItems, query)
+		}
+	}
+	if s.trackingHandler == nil {
+		return
+	}
+	updates := make([]queryItemsUpdate, 0, len(s.changedQueryItems))
+	for query := range s.changedQueryItems {
+		items, ok := s.QueryItems[query]
+		if !ok {
+			continue
+		}
+		sort := items.Decay(now)
+		score := 0.0
+		for _, value := range sort {
+			score += value
+		}
+		if score < queryItemsMinScore {
+			continue
+		}
+		updates = append(updates, queryItemsUpdate{key: queryOverrideKey(query), score: score, sort: sort})
+	}
+	s.changedQueryItems = nil
+	slices.SortFunc(updates, func(a, b queryItemsUpdate) int {
+		return cmp.Compare(b.score, a.score)
+	})
+	if len(updates) > queryItemsPublishLimit {
+		updates = updates[:queryItemsPublishLimit]
+	}
+	if len(updates) == 0 {
+		return
+	}
+	listener := s.trackingHandler
+	go func() {
+		for _, update := range updates {
+			if err := listener.SortOverrideChanged(update.key, &update.sort); err != nil {
+				log.Printf("Failed to publish %s: %v", update.key, err)
+			}
+		}
+	}()
+}
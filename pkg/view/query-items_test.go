@@ -0,0 +1,178 @@
+package view
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matst80/slask-finder/pkg/types"
+)
+
+func TestItemEventsAreAttributedToLatestSearch(t *testing.T) {
+	handler := MakeMemoryTrackingHandler(filepath.Join(t.TempDir(), "tracking.json"), 500)
+	search := func(session int64, query string) {
+		handler.HandleSearchEvent(SearchEvent{
+			BaseEvent:       &BaseEvent{Event: EVENT_SEARCH, SessionId: session},
+			Filters:         &types.Filters{},
+			NumberOfResults: 10,
+			Query:           query,
+		}, nil)
+	}
+	click := func(session int64, id uint) {
+		handler.HandleEvent(Event{
+			BaseEvent: &BaseEvent{Event: EVENT_ITEM_CLICK, SessionId: session},
+			BaseItem:  &BaseItem{Id: id},
+		}, nil)
+	}
+
+	handler.HandleSessionEvent(Session{BaseEvent: &BaseEvent{Event: EVENT_SESSION_START, SessionId: 1}})
+	handler.HandleSessionEvent(Session{BaseEvent: &BaseEvent{Event: EVENT_SESSION_START, SessionId: 2}})
+	// clicks without a search are not attributed
+	click(1, 5)
+	search(1, "USB/C kabel")
+	click(1, 7)
+	handler.HandleCartEvent(CartEvent{
+		BaseEvent: &BaseEvent{Event: CART_ADD, SessionId: 1},
+		BaseItem:  &BaseItem{Id: 8, Quantity: 1},
+	}, nil)
+	handler.HandlePurchaseEvent(PurchaseEvent{
+		BaseEvent: &BaseEvent{Event: CART_PURCHASE, SessionId: 1},
+		Items:     []BaseItem{{Id: 9, Quantity: 1}},
+	}, nil)
+	// a search older than the window is not credited
+	search(2, "tv")
+	handler.Sessions[2].LastSearch.TimeStamp -= queryAttributionWindow + 1
+	click(2, 7)
+
+	if len(handler.QueryItems) != 1 {
+		t.Fatalf("Expected items for one query, got %v", handler.QueryItems)
+	}
+	items := handler.GetQueryItems("usb c kabel")
+	weights := GetEventWeights().Global
+	if len(items) != 3 || items[7] != weights.Click.Value(0, 0) || items[8] != weights.Cart.Value(1, 0) || items[9] != weights.Purchase.Value(1, 0) {
+		t.Errorf("Expected click, cart and purchase weights, got %v", items)
+	}
+	if _, ok := items[5]; ok {
+		t.Errorf("Expected click before the search to be ignored, got %v", items)
+	}
+
+	dir := t.TempDir()
+	handler.ConnectPopularityListener(DiskPopularityListener(dir))
+	handler.DecayQueryItems()
+	path := filepath.Join(dir, "query-usb%20c%20kabel")
+	for i := 0; i < 100; i++ {
+		if _, err := os.Stat(path); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("Expected query items to be published as %s, got %v", path, err)
+	}
+	if len(handler.changedQueryItems) != 0 {
+		t.Errorf("Expected changed queries to be reset, got %v", handler.changedQueryItems)
+	}
+}
+
+func TestLeavingTheSearchListingEndsAttribution(t *testing.T) {
+	handler := MakeMemoryTrackingHandler(filepath.Join(t.TempDir(), "tracking.json"), 500)
+	handler.HandleSessionEvent(Session{BaseEvent: &BaseEvent{Event: EVENT_SESSION_START, SessionId: 1}})
+	handler.HandleSearchEvent(SearchEvent{
+		BaseEvent:       &BaseEvent{Event: EVENT_SEARCH, SessionId: 1, Context: "search"},
+		Filters:         &types.Filters{},
+		NumberOfResults: 10,
+		Query:           "tv",
+	}, nil)
+	click := func(context string, id uint) {
+		handler.HandleEvent(Event{
+			BaseEvent: &BaseEvent{Event: EVENT_ITEM_CLICK, SessionId: 1, Context: context},
+			BaseItem:  &BaseItem{Id: id},
+		}, nil)
+	}
+	click("search", 1)
+	// events without a context keep the search
+	click("", 2)
+	handler.HandleImpressionEvent(ImpressionEvent{
+		BaseEvent: &BaseEvent{Event: EVENT_ITEM_IMPRESS, SessionId: 1, Context: "category"},
+		Items:     []BaseItem{{Id: 3}},
+	}, nil)
+	click("category", 3)
+	click("search", 4)
+
+	items := handler.GetQueryItems("tv")
+	if len(items) != 2 || items[1] == 0 || items[2] == 0 {
+		t.Errorf("Expected only the clicks in the search listing, got %v", items)
+	}
+	if handler.Sessions[1].LastSearch != nil {
+		t.Errorf("Expected the search to end in the category listing, got %+v", handler.Sessions[1].LastSearch)
+	}
+	for _, judgement := range handler.GetJudgements(JudgementRequest{}) {
+		if judgement.Item == 3 || judgement.Item == 4 {
+			t.Errorf("Expected no judgement outside the search listing, got %+v", judgement)
+		}
+	}
+}
+
+func TestDecayQueryItemsPublishesTopQueries(t *testing.T) {
+	handler := MakeMemoryTrackingHandler(filepath.Join(t.TempDir(), "tracking.json"), 500)
+	now := time.Now().Unix()
+	click := GetEventWeights().Global.Click.Value(0, 0)
+	handler.changedQueryItems = make(map[string]struct{})
+	for i := 0; i <= queryItemsPublishLimit+20; i++ {
+		query := fmt.Sprintf("query %d", i)
+		list := NewDecayList(ProfileQueryItems)
+		// query 0 only has an impression worth of engagement
+		list.Add(1, DecayEvent{TimeStamp: now, Value: click * float64(i) / 2})
+		handler.QueryItems[query] = &list
+		handler.changedQueryItems[query] = struct{}{}
+	}
+
+	dir := t.TempDir()
+	handler.ConnectPopularityListener(DiskPopularityListener(dir))
+	handler.DecayQueryItems()
+	var files []os.DirEntry
+	for i := 0; i < 100; i++ {
+		files, _ = os.ReadDir(dir)
+		if len(files) >= queryItemsPublishLimit {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+	files, _ = os.ReadDir(dir)
+	if len(files) != queryItemsPublishLimit {
+		t.Fatalf("Expected %d published queries, got %d", queryItemsPublishLimit, len(files))
+	}
+	for _, query := range []string{"query 0", "query 20"} {
+		if _, err := os.Stat(filepath.Join(dir, fileName(queryOverrideKey(query)))); err == nil {
+			t.Errorf("Expected %s to be left out", query)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, fileName(queryOverrideKey("query 120")))); err != nil {
+		t.Errorf("Expected the most engaged query to be published, got %v", err)
+	}
+}
+
+func TestOverrideFileName(t *testing.T) {
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"popular", "popular"},
+		{"query-usb/c", "query-usb%2Fc"},
+		{"..", "%25.."},
+		{"", "%25"},
+	}
+	for _, tt := range tests {
+		if got := fileName(tt.key); got != tt.want {
+			t.Errorf("fileName(%q) = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+	long := fileName("query-" + strings.Repeat("a", 300))
+	if len(long) != maxFileNameLength || long == fileName("query-"+strings.Repeat("a", 301)) {
+		t.Errorf("Expected long keys to be cut with a distinct hash, got %s", long)
+	}
+}
@@ -0,0 +1,230 @@
+package view
+
+import (
+	"log"
+	"strings"
+)
+
+// rememberQueryCountry keeps the country a normalized query was seen with so
+// the query can be normalized again with the same rules, the latest country
+// wins when a query is seen from more than one
+func (s *PersistentMemoryTrackingHandler) rememberQueryCountry(query string, country string) {
+	if s.QueryCountries == nil {
+		s.QueryCountries = make(map[string]string)
+	}
+	s.QueryCountries[query] = strings.ToLower(country)
+}
+
+// rekey moves the values to the key given by rename and merges the values
+// that end up on the same key, values renamed to an empty key are dropped
+func rekey[V any](values map[string]V, rename func(string) string, merge func(a, b V) V) map[string]V {
+	result := make(map[string]V, len(values))
+	for query, value := range values {
+		key := rename(query)
+		if key == "" {
+			continue
+		}
+		if existing, ok := result[key]; ok {
+			value = merge(existing, value)
+		}
+		result[key] = value
+	}
+	return result
+}
+
+// renormalizeQueries normalizes the stored queries again with the current
+// rules for the country they were seen with and merges every query keyed
+// store on the new keys, queries without a known country are left as they are
+func (s *PersistentMemoryTrackingHandler) renormalizeQueries() {
+	renamed := make(map[string]string)
+	for query, country := range s.QueryCountries {
+		if key := NormalizeQuery(query, country); key != query {
+			renamed[query] = key
+		}
+	}
+	if len(renamed) == 0 {
+		return
+	}
+	rename := func(query string) string {
+		if key, ok := renamed[query]; ok {
+			return key
+		}
+		return query
+	}
+
+	s.QueryCountries = rekey(s.QueryCountries, rename, func(a, b string) string { return a })
+	s.Queries = rekey(s.Queries, rename, func(a, b uint) uint { return a + b })
+	s.QueryEvents = rekey(s.QueryEvents, rename, mergeQueryMatchers)
+	s.NoResults = rekey(s.NoResults, rename, mergeNoResults)
+	s.QueryItems = rekey(s.QueryItems, rename, func(a, b *DecayList) *DecayList {
+		a.Merge(*b)
+		return a
+	})
+	s.Judgements = rekey(s.Judgements, rename, mergeQueryJudgements)
+	s.Reformulations = rekey(s.Reformulations, rename, mergeReformulationTargets)
+	for from, targets := range s.Reformulations {
+		targets = rekey(targets, rename, mergeReformulations)
+		// both queries can end up on the same key
+		delete(targets, from)
+		if len(targets) == 0 {
+			delete(s.Reformulations, from)
+			continue
+		}
+		s.Reformulations[from] = targets
+	}
+	for _, w := range s.Windows {
+		w.Queries = rekey(w.Queries, rename, func(a, b *DecayPopularity) *DecayPopularity {
+			a.Merge(b)
+			return a
+		})
+	}
+
+	// sorted by score, the best scored duplicate is kept
+	sorted := make([]QueryResult, 0, len(s.SortedQueries))
+	seen := make(map[string]struct{}, len(s.SortedQueries))
+	for _, result := range s.SortedQueries {
+		result.Query = rename(result.Query)
+		if _, ok := seen[result.Query]; ok || result.Query == "" {
+			continue
+		}
+		seen[result.Query] = struct{}{}
+		sorted = append(sorted, result)
+	}
+	s.SortedQueries = sorted
+	log.Printf("Normalized %d stored queries again", len(renamed))
+}
+
+// pruneQueryCountries forgets the countries of queries no store keeps
+func (s *PersistentMemoryTrackingHandler) pruneQueryCountries() {
+	for query := range s.QueryCountries {
+		if !s.hasQuery(query) {
+			delete(s.QueryCountries, query)
+		}
+	}
+}
+
+func (s *PersistentMemoryTrackingHandler) hasQuery(query string) bool {
+	if _, ok := s.Queries[query]; ok {
+		return true
+	}
+	if _, ok := s.QueryEvents[query]; ok {
+		return true
+	}
+	if _, ok := s.NoResults[query]; ok {
+		return true
+	}
+	if _, ok := s.QueryItems[query]; ok {
+		return true
+	}
+	if _, ok := s.Judgements[query]; ok {
+		return true
+	}
+	if _, ok := s.Reformulations[query]; ok {
+		return true
+	}
+	for _, w := range s.Windows {
+		if _, ok := w.Queries[query]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeQueryMatchers adds the popularity of b to a, facets only seen for b
+// are added as well
+func mergeQueryMatchers(a, b QueryMatcher) QueryMatcher {
+	if a.Popularity == nil {
+		a.Popularity = b.Popularity
+	} else {
+		a.Popularity.Merge(b.Popularity)
+	}
+	if a.KeyFields == nil {
+		a.KeyFields = make(map[uint]QueryKeyData)
+	}
+	for id, field := range b.KeyFields {
+		if _, ok := a.KeyFields[id]; !ok {
+			a.KeyFields[id] = field
+		}
+	}
+	return a
+}
+
+func mergeNoResults(a, b *NoResultQuery) *NoResultQuery {
+	a.Frequency.Merge(GetDecayProfile(ProfileNoResults), b.Frequency)
+	a.Count += b.Count
+	if a.FirstSeen == 0 || (b.FirstSeen != 0 && b.FirstSeen < a.FirstSeen) {
+		a.FirstSeen = b.FirstSeen
+	}
+	a.LastSeen = max(a.LastSeen, b.LastSeen)
+	if a.Sessions == nil {
+		a.Sessions = make(map[int64]uint)
+	}
+	for session, count := range b.Sessions {
+		a.Sessions[session] += count
+	}
+	if a.Filters == nil {
+		a.Filters = make(map[uint]*NoResultFilter)
+	}
+	for id, filter := range b.Filters {
+		existing, ok := a.Filters[id]
+		if !ok {
+			a.Filters[id] = filter
+			continue
+		}
+		existing.Count += filter.Count
+		if existing.Values == nil {
+			existing.Values = make(map[string]uint)
+		}
+		for value, count := range filter.Values {
+			existing.Values[value] += count
+		}
+	}
+	return a
+}
+
+func mergeQueryJudgements(a, b *QueryJudgements) *QueryJudgements {
+	if a.Searches == nil {
+		a.Searches = make(map[int64]uint)
+	}
+	for day, count := range b.Searches {
+		a.Searches[day] += count
+	}
+	if a.Items == nil {
+		a.Items = make(map[uint]map[int64]*JudgementCounts)
+	}
+	for id, days := range b.Items {
+		existing, ok := a.Items[id]
+		if !ok {
+			a.Items[id] = days
+			continue
+		}
+		for day, counts := range days {
+			if current, ok := existing[day]; ok {
+				current.merge(counts)
+			} else {
+				existing[day] = counts
+			}
+		}
+	}
+	return a
+}
+
+func mergeReformulations(a, b *Reformulation) *Reformulation {
+	a.Score.Merge(GetDecayProfile(ProfileReformulation), b.Score)
+	a.Count += b.Count
+	a.Clicks += b.Clicks
+	a.Carts += b.Carts
+	a.LastSeen = max(a.LastSeen, b.LastSeen)
+	return a
+}
+
+func mergeReformulationTargets(a, b map[string]*Reformulation) map[string]*Reformulation {
+	for to, reformulation := range b {
+		if existing, ok := a[to]; ok {
+			mergeReformulations(existing, reformulation)
+		} else {
+			a[to] = reformulation
+		}
+	}
+	return a
+}
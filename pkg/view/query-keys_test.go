@@ -0,0 +1,75 @@
+package view
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestRenormalizeStoredQueries(t *testing.T) {
+	handler := MakeMemoryTrackingHandler(filepath.Join(t.TempDir(), "tracking.json"), 500)
+	handler.QueryCountries = map[string]string{"tv benk": "", "tvbenk": "", "skal för iphone": "se", "tv": "", "radio": ""}
+	for query, value := range map[string]float64{"tv benk": 10, "tvbenk": 30} {
+		popularity := NewDecayPopularity(ProfileQuery)
+		popularity.Add(DecayEvent{TimeStamp: 1000, Value: value})
+		handler.Queries[query] = uint(value)
+		handler.QueryEvents[query] = QueryMatcher{Profile: ProfileQuery, Popularity: popularity, KeyFields: map[uint]QueryKeyData{uint(value): {}}}
+		handler.NoResults[query] = &NoResultQuery{Count: uint(value)}
+		items := NewDecayList(ProfileQueryItems)
+		items.Add(uint(value), DecayEvent{TimeStamp: 1000, Value: value})
+		handler.QueryItems[query] = &items
+		handler.Judgements[query] = &QueryJudgements{Searches: map[int64]uint{0: uint(value)}}
+		handler.addQueryEvent(query, DecayEvent{TimeStamp: 1000, Value: value})
+	}
+	handler.Queries["skal för iphone"] = 2
+	handler.Reformulations["tv"] = map[string]*Reformulation{"tv benk": {Count: 1}, "tvbenk": {Count: 2}}
+	handler.Reformulations["tv benk"] = map[string]*Reformulation{"tvbenk": {Count: 1}}
+	handler.SortedQueries = []QueryResult{{Query: "tvbenk", Score: 30}, {Query: "tv benk", Score: 10}, {Query: "Samsung TV", Score: 5}}
+
+	handler.renormalizeQueries()
+	if len(handler.Queries) != 2 || handler.Queries["tvbenk"] != 40 || handler.Queries["skal iphone"] != 2 {
+		t.Errorf("Expected the queries to be normalized with their own country, got %v", handler.Queries)
+	}
+	matcher, ok := handler.QueryEvents["tvbenk"]
+	if len(handler.QueryEvents) != 1 || !ok || matcher.Popularity.Sum != 40 || len(matcher.KeyFields) != 2 {
+		t.Errorf("Expected the query events to be merged, got %+v", handler.QueryEvents)
+	}
+	if len(handler.NoResults) != 1 || handler.NoResults["tvbenk"].Count != 40 {
+		t.Errorf("Expected the no results to be merged, got %+v", handler.NoResults)
+	}
+	if len(handler.QueryItems) != 1 || handler.QueryItems["tvbenk"].Len() != 2 {
+		t.Errorf("Expected the query items to be merged, got %+v", handler.QueryItems)
+	}
+	if len(handler.Judgements) != 1 || handler.Judgements["tvbenk"].Searches[0] != 40 {
+		t.Errorf("Expected the judgements to be merged, got %+v", handler.Judgements)
+	}
+	if len(handler.Reformulations) != 1 || len(handler.Reformulations["tv"]) != 1 || handler.Reformulations["tv"]["tvbenk"].Count != 3 {
+		t.Errorf("Expected the reformulations to be merged, got %+v", handler.Reformulations)
+	}
+	for name, w := range handler.Windows {
+		if popularity := w.Queries["tvbenk"]; len(w.Queries) != 1 || popularity == nil || popularity.Sum != 40 {
+			t.Errorf("Expected the queries of window %s to be merged, got %+v", name, w.Queries)
+		}
+	}
+	// queries without a known country keep their key
+	if len(handler.SortedQueries) != 2 || handler.SortedQueries[0].Query != "tvbenk" || handler.SortedQueries[1].Query != "Samsung TV" {
+		t.Errorf("Expected sorted queries without duplicates, got %+v", handler.SortedQueries)
+	}
+	if handler.QueryCountries["skal iphone"] != "se" || len(handler.QueryCountries) != 4 {
+		t.Errorf("Expected the countries to follow the new keys, got %v", handler.QueryCountries)
+	}
+	handler.pruneQueryCountries()
+	if _, ok := handler.QueryCountries["radio"]; ok || len(handler.QueryCountries) != 3 {
+		t.Errorf("Expected countries of queries no store keeps to be pruned, got %v", handler.QueryCountries)
+	}
+}
+
+func TestSearchRemembersCountry(t *testing.T) {
+	handler := MakeMemoryTrackingHandler(filepath.Join(t.TempDir(), "tracking.json"), 500)
+	handler.HandleSuggestEvent(SuggestEvent{
+		BaseEvent: &BaseEvent{Event: EVENT_SUGGEST, SessionId: 1, Country: "SE"},
+		Value:     "skal för iphone",
+	}, nil)
+	if country, ok := handler.QueryCountries["skal iphone"]; !ok || country != "se" {
+		t.Errorf("Expected the country to be kept with the query, got %v", handler.QueryCountries)
+	}
+}
@@ -0,0 +1,308 @@
+package view
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// LanguageNormalization decides how queries in one language are normalized
+// after unicode normalization and lowercasing
+type LanguageNormalization struct {
+	// replaced before anything else, used to keep the letters of the language
+	// when the query is typed on a keyboard for a neighbouring language
+	Replace map[string]string `json:"replace,omitempty"`
+	// strips accents and folds letters like ø and æ to plain latin
+	FoldDiacritics bool `json:"fold_diacritics,omitempty"`
+	// joins words written with a hyphen or dash, tv-benk becomes tvbenk
+	JoinHyphens bool     `json:"join_hyphens,omitempty"`
+	StopWords   []string `json:"stop_words,omitempty"`
+	// words written as one, tv benk becomes tvbenk when tvbenk is listed
+	Compounds []string `json:"compounds,omitempty"`
+}
+
+func (l LanguageNormalization) clone() LanguageNormalization {
+	l.Replace = maps.Clone(l.Replace)
+	l.StopWords = slices.Clone(l.StopWords)
+	l.Compounds = slices.Clone(l.Compounds)
+	return l
+}
+
+func (l LanguageNormalization) validate() error {
+	for from := range l.Replace {
+		if from == "" {
+			return fmt.Errorf("empty replacement key")
+		}
+	}
+	return nil
+}
+
+// QueryNormalization holds the normalization per language keyed by the
+// country of the event, countries without an entry use the default
+type QueryNormalization struct {
+	Default   LanguageNormalization            `json:"default"`
+	Languages map[string]LanguageNormalization `json:"languages"`
+}
+
+func (n QueryNormalization) Validate() error {
+	if err := n.Default.validate(); err != nil {
+		return fmt.Errorf("default %w", err)
+	}
+	for country, language := range n.Languages {
+		if err := language.validate(); err != nil {
+			return fmt.Errorf("%s %w", country, err)
+		}
+	}
+	return nil
+}
+
+// defaultCompounds are shared by the default languages, each language
+// normalizes them with its own rules
+var defaultCompounds = []string{"tvbänk", "tvbenk", "tvbord", "tvfäste", "tvfeste", "tvmöbel", "tvstativ"}
+
+func DefaultQueryNormalization() QueryNormalization {
+	return QueryNormalization{
+		Default: LanguageNormalization{Compounds: slices.Clone(defaultCompounds)},
+		Languages: map[string]LanguageNormalization{
+			"se": {
+				Replace:     map[string]string{"æ": "ä", "ø": "ö"},
+				JoinHyphens: true,
+				Compounds:   slices.Clone(defaultCompounds),
+				StopWords:   []string{"och", "eller", "för", "till", "med", "av", "på", "den", "det", "en", "ett"},
+			},
+			"no": {
+				Replace:     map[string]string{"ä": "æ", "ö": "ø"},
+				JoinHyphens: true,
+				Compounds:   slices.Clone(defaultCompounds),
+				StopWords:   []string{"og", "eller", "for", "til", "med", "av", "på", "den", "det", "en", "et"},
+			},
+			"dk": {
+				Replace:     map[string]string{"ä": "æ", "ö": "ø"},
+				JoinHyphens: true,
+				Compounds:   slices.Clone(defaultCompounds),
+				StopWords:   []string{"og", "eller", "for", "til", "med", "af", "på", "den", "det", "en", "et"},
+			},
+			"fi": {
+				JoinHyphens: true,
+				Compounds:   slices.Clone(defaultCompounds),
+				StopWords:   []string{"ja", "tai"},
+			},
+		},
+	}
+}
+
+// queryNormalizer is the compiled form of a LanguageNormalization
+type queryNormalizer struct {
+	replace     *strings.Replacer
+	fold        bool
+	joinHyphens bool
+	stopWords   map[string]struct{}
+	compounds   map[string]struct{}
+}
+
+func newQueryNormalizer(language LanguageNormalization) *queryNormalizer {
+	n := &queryNormalizer{
+		fold:        language.FoldDiacritics,
+		joinHyphens: language.JoinHyphens,
+		stopWords:   make(map[string]struct{}, len(language.StopWords)),
+		compounds:   make(map[string]struct{}, len(language.Compounds)),
+	}
+	if len(language.Replace) > 0 {
+		pairs := make([]string, 0, len(language.Replace)*2)
+		// sorted so overlapping keys are replaced the same way every time
+		for _, from := range slices.Sorted(maps.Keys(language.Replace)) {
+			pairs = append(pairs, norm.NFKC.String(strings.ToLower(from)), language.Replace[from])
+		}
+		n.replace = strings.NewReplacer(pairs...)
+	}
+	// stop words go through the same steps as the queries they are removed from
+	for _, word := range language.StopWords {
+		if word = n.normalize(word); word != "" {
+			n.stopWords[word] = struct{}{}
+		}
+	}
+	for _, word := range language.Compounds {
+		if word = strings.ReplaceAll(n.normalize(word), " ", ""); word != "" {
+			n.compounds[word] = struct{}{}
+		}
+	}
+	return n
+}
+
+// letters without a decomposition that diacritic folding still maps to latin
+var foldedLetters = strings.NewReplacer("ø", "o", "æ", "ae", "œ", "oe", "ß", "ss", "ł", "l", "đ", "d", "þ", "th")
+
+func foldDiacritics(query string) string {
+	folded, _, err := transform.String(transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC), query)
+	if err != nil {
+		return query
+	}
+	return foldedLetters.Replace(folded)
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r)
+}
+
+// separate replaces punctuation and symbols with spaces, hyphens inside words
+// are dropped when joining and decimal separators inside numbers are kept
+func (n *queryNormalizer) separate(query string) string {
+	input := []rune(query)
+	var b strings.Builder
+	b.Grow(len(query))
+	for i, r := range input {
+		if isWordRune(r) {
+			b.WriteRune(r)
+			continue
+		}
+		inside := i > 0 && i < len(input)-1 && isWordRune(input[i-1]) && isWordRune(input[i+1])
+		if inside && n.joinHyphens && unicode.In(r, unicode.Hyphen, unicode.Dash) {
+			continue
+		}
+		if inside && (r == '.' || r == ',') && unicode.IsDigit(input[i-1]) && unicode.IsDigit(input[i+1]) {
+			b.WriteRune(r)
+			continue
+		}
+		b.WriteRune(' ')
+	}
+	return b.String()
+}
+
+// maxCompoundParts is the most words joined into one compound
+const maxCompoundParts = 3
+
+// joinCompounds joins neighbouring words that together make a known compound
+func (n *queryNormalizer) joinCompounds(words []string) []string {
+	result := make([]string, 0, len(words))
+	for i := 0; i < len(words); {
+		parts := 1
+		for j := min(len(words), i+maxCompoundParts); j > i+1; j-- {
+			if _, ok := n.compounds[strings.Join(words[i:j], "")]; ok {
+				parts = j - i
+				break
+			}
+		}
+		result = append(result, strings.Join(words[i:i+parts], ""))
+		i += parts
+	}
+	return result
+}
+
+func (n *queryNormalizer) normalize(query string) string {
+	query = strings.ToLower(norm.NFKC.String(query))
+	if n.replace != nil {
+		query = n.replace.Replace(query)
+	}
+	if n.fold {
+		query = foldDiacritics(query)
+	}
+	words := strings.Fields(n.separate(query))
+	if len(n.compounds) > 0 {
+		words = n.joinCompounds(words)
+	}
+	if len(n.stopWords) > 0 {
+		kept := slices.DeleteFunc(slices.Clone(words), func(word string) bool {
+			_, ok := n.stopWords[word]
+			return ok
+		})
+		// a query of only stop words is kept as it is
+		if len(kept) > 0 {
+			words = kept
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+var (
+	normalizationMu    sync.RWMutex
+	queryNormalization = DefaultQueryNormalization()
+	normalizers        = compileNormalizers(queryNormalization)
+	normalizationPath  string
+)
+
+func compileNormalizers(normalization QueryNormalization) map[string]*queryNormalizer {
+	result := make(map[string]*queryNormalizer, len(normalization.Languages)+1)
+	result[""] = newQueryNormalizer(normalization.Default)
+	for country, language := range normalization.Languages {
+		result[strings.ToLower(country)] = newQueryNormalizer(language)
+	}
+	return result
+}
+
+// GetQueryNormalization returns a copy that is safe to decode changes into
+func GetQueryNormalization() QueryNormalization {
+	normalizationMu.RLock()
+	defer normalizationMu.RUnlock()
+	result := QueryNormalization{
+		Default:   queryNormalization.Default.clone(),
+		Languages: make(map[string]LanguageNormalization, len(queryNormalization.Languages)),
+	}
+	for country, language := range queryNormalization.Languages {
+		result.Languages[country] = language.clone()
+	}
+	return result
+}
+
+func SetQueryNormalization(normalization QueryNormalization) error {
+	if err := normalization.Validate(); err != nil {
+		return err
+	}
+	compiled := compileNormalizers(normalization)
+	normalizationMu.Lock()
+	defer normalizationMu.Unlock()
+	if normalizationPath != "" {
+		if err := writeSnapshot(normalizationPath, normalization); err != nil {
+			return err
+		}
+	}
+	queryNormalization = normalization
+	normalizers = compiled
+	return nil
+}
+
+// LoadQueryNormalization reads the file on top of the defaults, languages in
+// the file replace the default for that language
+func LoadQueryNormalization(path string) error {
+	normalization := DefaultQueryNormalization()
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		if err = json.Unmarshal(data, &normalization); err != nil {
+			return err
+		}
+		if err = normalization.Validate(); err != nil {
+			return err
+		}
+		log.Printf("Loaded query normalization from %s", path)
+	}
+	compiled := compileNormalizers(normalization)
+	normalizationMu.Lock()
+	defer normalizationMu.Unlock()
+	queryNormalization = normalization
+	normalizers = compiled
+	normalizationPath = path
+	return nil
+}
+
+// NormalizeQuery normalizes the query with the rules for the country
+func NormalizeQuery(query string, country string) string {
+	normalizationMu.RLock()
+	n, ok := normalizers[strings.ToLower(country)]
+	if !ok {
+		n = normalizers[""]
+	}
+	normalizationMu.RUnlock()
+	return n.normalize(query)
+}
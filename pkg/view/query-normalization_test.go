@@ -0,0 +1,130 @@
+package view
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/matst80/slask-finder/pkg/types"
+)
+
+func resetQueryNormalization(t *testing.T) {
+	t.Cleanup(func() {
+		normalizationMu.Lock()
+		queryNormalization = DefaultQueryNormalization()
+		normalizers = compileNormalizers(queryNormalization)
+		normalizationPath = ""
+		normalizationMu.Unlock()
+	})
+}
+
+func TestNormalizeQuery(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		country string
+		want    string
+	}{
+		{"lowercase and trim", "  Samsung TV ", "", "samsung tv"},
+		{"collapse whitespace", "samsung \t  tv", "", "samsung tv"},
+		{"punctuation", "samsung, tv!", "", "samsung tv"},
+		{"hyphen kept apart without language", "USB-C kabel", "", "usb c kabel"},
+		{"hyphen joined for swedish", "USB-C kabel", "se", "usbc kabel"},
+		{"compound joined", "tv benk", "", "tvbenk"},
+		{"compound joined around other words", "svart tv benk 140", "se", "svart tvbenk 140"},
+		{"hyphen joined for swedish", "TV-benk", "se", "tvbenk"},
+		{"country is case insensitive", "TV-benk", "SE", "tvbenk"},
+		{"decimal kept", "5.1 högtalare", "se", "5.1 högtalare"},
+		{"full width", "ｉＰｈｏｎｅ", "", "iphone"},
+		{"decomposed letters", "hörlurar", "se", "hörlurar"},
+		{"norwegian letters on swedish site", "støvsuger", "se", "stövsuger"},
+		{"swedish letters on norwegian site", "stövsuger", "no", "støvsuger"},
+		{"stop words", "skal för iphone", "se", "skal iphone"},
+		{"only stop words", "och", "se", "och"},
+		{"unknown country uses default", "skal för iphone", "xx", "skal för iphone"},
+		{"only punctuation", "***", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NormalizeQuery(tt.query, tt.country); got != tt.want {
+				t.Errorf("NormalizeQuery(%q, %q) = %q, want %q", tt.query, tt.country, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompoundQueriesShareKey(t *testing.T) {
+	for _, country := range []string{"", "se"} {
+		for _, query := range []string{"tv benk", "tvbenk", "TV-benk", "TV benk"} {
+			if got := NormalizeQuery(query, country); got != "tvbenk" {
+				t.Errorf("NormalizeQuery(%q, %q) = %q, want tvbenk", query, country, got)
+			}
+		}
+	}
+}
+
+func TestLoadQueryNormalization(t *testing.T) {
+	resetQueryNormalization(t)
+	path := filepath.Join(t.TempDir(), "query-normalization.json")
+	if err := os.WriteFile(path, []byte(`{"languages":{"de":{"fold_diacritics":true,"stop_words":["für"]}}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadQueryNormalization(path); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if got := NormalizeQuery("Hülle für Größe", "de"); got != "hulle grosse" {
+		t.Errorf("Expected folded query without stop words, got %q", got)
+	}
+	if got := NormalizeQuery("TV-benk", "se"); got != "tvbenk" {
+		t.Errorf("Expected default languages to be kept, got %q", got)
+	}
+
+	normalization := GetQueryNormalization()
+	normalization.Languages["se"] = LanguageNormalization{}
+	if got := NormalizeQuery("TV-benk", "se"); got != "tvbenk" {
+		t.Errorf("Expected changes to the copy to not apply, got %q", got)
+	}
+	if err := SetQueryNormalization(normalization); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if got := NormalizeQuery("TV-benk", "se"); got != "tv benk" {
+		t.Errorf("Expected updated rules, got %q", got)
+	}
+	if err := LoadQueryNormalization(path); err != nil || NormalizeQuery("TV-benk", "se") != "tv benk" {
+		t.Errorf("Expected updated rules to be written to the config file")
+	}
+	normalization.Default.Replace = map[string]string{"": "x"}
+	if err := SetQueryNormalization(normalization); err == nil {
+		t.Errorf("Expected empty replacement to be rejected")
+	}
+}
+
+func TestSearchEventsShareNormalizedQuery(t *testing.T) {
+	handler := MakeMemoryTrackingHandler(filepath.Join(t.TempDir(), "tracking.json"), 500)
+	for _, query := range []string{"TV-benk", "tvbenk ", "tv–benk!"} {
+		handler.HandleSearchEvent(SearchEvent{
+			BaseEvent:       &BaseEvent{Event: EVENT_SEARCH, SessionId: 1, Country: "se"},
+			Filters:         &types.Filters{},
+			NumberOfResults: 10,
+			Query:           query,
+		}, nil)
+	}
+	if len(handler.QueryEvents) != 1 || handler.Queries["tvbenk"] != 3 {
+		t.Errorf("Expected one query key, got %v", handler.Queries)
+	}
+	handler.HandleSuggestEvent(SuggestEvent{
+		BaseEvent: &BaseEvent{Event: EVENT_SUGGEST, SessionId: 1, Country: "se"},
+		Value:     "TV-Benk",
+	}, nil)
+	if handler.Queries["tvbenk"] != 4 {
+		t.Errorf("Expected suggest events to use the same key, got %v", handler.Queries)
+	}
+	handler.HandleSearchEvent(SearchEvent{
+		BaseEvent: &BaseEvent{Event: EVENT_SEARCH, SessionId: 1, Country: "se"},
+		Filters:   &types.Filters{},
+		Query:     "Väggfäste för TV",
+	}, nil)
+	if _, ok := handler.NoResults["väggfäste tv"]; !ok || len(handler.NoResults) != 1 {
+		t.Errorf("Expected normalized query without results, got %+v", handler.NoResults)
+	}
+}
@@ -0,0 +1,206 @@
+package view
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	ProfileReformulation = "reformulation"
+	// a search only reformulates the previous one within this many seconds
+	reformulationWindow = 600
+	// every reformulation counts once, a click or cart after the new query
+	// shows the user found what the first query missed
+	reformulationBase  = 1
+	reformulationClick = 2
+	reformulationCart  = 4
+	reformulationLimit = 100
+)
+
+const (
+	ReformulationSpelling   = "spelling"
+	ReformulationRefinement = "refinement"
+	ReformulationSynonym    = "synonym"
+	ReformulationRewrite    = "rewrite"
+)
+
+// SessionSearch is the latest search in a session, Previous is the query it
+// reformulated
+type SessionSearch struct {
+	Query     string `json:"query"`
+	Context   string `json:"context,omitempty"`
+	Previous  string `json:"previous,omitempty"`
+	TimeStamp int64  `json:"ts"`
+	Clicked   bool   `json:"clicked,omitempty"`
+	Carted    bool   `json:"carted,omitempty"`
+}
+
+type Reformulation struct {
+	Score    DecayValue `json:"score"`
+	Count    uint       `json:"count"`
+	Clicks   uint       `json:"clicks"`
+	Carts    uint       `json:"carts"`
+	LastSeen int64      `json:"last_seen"`
+}
+
+func (r *Reformulation) add(value float64, ts int64) {
+	r.Score.Add(GetDecayProfile(ProfileReformulation), DecayEvent{TimeStamp: ts, Value: value})
+	r.LastSeen = max(r.LastSeen, ts)
+}
+
+func (s *PersistentMemoryTrackingHandler) getReformulation(from string, to string) *Reformulation {
+	if s.Reformulations == nil {
+		s.Reformulations = make(map[string]map[string]*Reformulation)
+	}
+	targets, ok := s.Reformulations[from]
+	if !ok {
+		targets = make(map[string]*Reformulation)
+		s.Reformulations[from] = targets
+	}
+	reformulation, ok := targets[to]
+	if !ok {
+		reformulation = &Reformulation{}
+		targets[to] = reformulation
+	}
+	return reformulation
+}
+
+// trackSearchReformulation pairs the query with the previous search in the
+// session, repeating the same query only refreshes the search
+func (s *PersistentMemoryTrackingHandler) trackSearchReformulation(session *SessionData, query string, context string, ts int64) {
+	if session == nil || query == "" {
+		return
+	}
+	last := session.LastSearch
+	if last != nil && last.Query == query {
+		last.TimeStamp = ts
+		last.Context = context
+		return
+	}
+	search := &SessionSearch{Query: query, Context: context, TimeStamp: ts}
+	if last != nil && ts-last.TimeStamp <= reformulationWindow {
+		search.Previous = last.Query
+		reformulation := s.getReformulation(last.Query, query)
+		reformulation.Count++
+		reformulation.add(reformulationBase, ts)
+	}
+	session.LastSearch = search
+}
+
+// creditReformulation adds the engagement after a reformulated search to the
+// pair, once per search and type of engagement
+func (s *PersistentMemoryTrackingHandler) creditReformulation(session *SessionData, cart bool, ts int64) {
+	if session == nil || session.LastSearch == nil {
+		return
+	}
+	search := session.LastSearch
+	if search.Previous == "" || ts-search.TimeStamp > reformulationWindow {
+		return
+	}
+	reformulation := s.getReformulation(search.Previous, search.Query)
+	if cart && !search.Carted {
+		search.Carted = true
+		reformulation.Carts++
+		reformulation.add(reformulationCart, ts)
+	} else if !cart && !search.Clicked {
+		search.Clicked = true
+		reformulation.Clicks++
+		reformulation.add(reformulationClick, ts)
+	}
+}
+
+func compactReformulations(reformulations map[string]map[string]*Reformulation, now int64) {
+	profile := GetDecayProfile(ProfileReformulation)
+	maps.DeleteFunc(reformulations, func(from string, targets map[string]*Reformulation) bool {
+		maps.DeleteFunc(targets, func(to string, value *Reformulation) bool {
+			return value.Score.ValueAt(profile, now) < 0.0002
+		})
+		return len(targets) == 0
+	})
+}
+
+// classifyReformulation tells a fixed typo from a narrowed query, a query
+// with other words for the same thing and a query changed in other ways
+func classifyReformulation(from string, to string) string {
+	fromWords := strings.Fields(from)
+	toWords := strings.Fields(to)
+	shared := 0
+	for _, word := range fromWords {
+		if slices.Contains(toWords, word) {
+			shared++
+		}
+	}
+	fromRunes := []rune(from)
+	if shared < len(fromWords) && shared < len(toWords) && editDistance(fromRunes, []rune(to)) <= maxEdits(len(fromRunes)) {
+		return ReformulationSpelling
+	}
+	if shared == len(fromWords) || shared == len(toWords) {
+		return ReformulationRefinement
+	}
+	if shared == 0 && len(fromWords) <= 2 && len(toWords) <= 2 {
+		return ReformulationSynonym
+	}
+	return ReformulationRewrite
+}
+
+type ReformulationResult struct {
+	From     string  `json:"from"`
+	To       string  `json:"to"`
+	Kind     string  `json:"kind"`
+	Score    float64 `json:"score"`
+	Count    uint    `json:"count"`
+	Clicks   uint    `json:"clicks"`
+	Carts    uint    `json:"carts"`
+	LastSeen int64   `json:"last_seen"`
+}
+
+// GetReformulations ranks the reformulation pairs by decayed score, kind
+// limits the result to one kind of candidate
+func (s *PersistentMemoryTrackingHandler) GetReformulations(kind string, limit int) ([]ReformulationResult, error) {
+	switch kind {
+	case "", ReformulationSpelling, ReformulationRefinement, ReformulationSynonym, ReformulationRewrite:
+	default:
+		return nil, fmt.Errorf("unknown reformulation kind %s", kind)
+	}
+	if limit <= 0 {
+		limit = reformulationLimit
+	}
+	s.mu.RLock()
+	now := time.Now().Unix()
+	profile := GetDecayProfile(ProfileReformulation)
+	result := make([]ReformulationResult, 0)
+	for from, targets := range s.Reformulations {
+		for to, reformulation := range targets {
+			score := reformulation.Score.ValueAt(profile, now)
+			if score <= 0 {
+				continue
+			}
+			pairKind := classifyReformulation(from, to)
+			if kind != "" && pairKind != kind {
+				continue
+			}
+			result = append(result, ReformulationResult{
+				From:     from,
+				To:       to,
+				Kind:     pairKind,
+				Score:    score,
+				Count:    reformulation.Count,
+				Clicks:   reformulation.Clicks,
+				Carts:    reformulation.Carts,
+				LastSeen: reformulation.LastSeen,
+			})
+		}
+	}
+	s.mu.RUnlock()
+	slices.SortFunc(result, func(a, b ReformulationResult) int {
+		if c := cmp.Compare(b.Score, a.Score); c != 0 {
+			return c
+		}
+		return strings.Compare(a.From+" "+a.To, b.From+" "+b.To)
+	})
+	return result[:min(limit, len(result))], nil
+}
@@ -0,0 +1,86 @@
+package view
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/matst80/slask-finder/pkg/types"
+)
+
+func TestClassifyReformulation(t *testing.T) {
+	tests := []struct {
+		from string
+		to   string
+		want string
+	}{
+		{"iphnoe", "iphone", ReformulationSpelling},
+		{"playstaion 5", "playstation 5", ReformulationSpelling},
+		{"tv", "samsung tv", ReformulationRefinement},
+		{"samsung tv 55", "samsung tv", ReformulationRefinement},
+		{"mobil", "telefon", ReformulationSynonym},
+		{"billig tv", "tv 32 tum", ReformulationRewrite},
+	}
+	for _, tt := range tests {
+		if got := classifyReformulation(tt.from, tt.to); got != tt.want {
+			t.Errorf("classifyReformulation(%q, %q) = %q, want %q", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestReformulationsAreMinedFromSessions(t *testing.T) {
+	handler := MakeMemoryTrackingHandler(filepath.Join(t.TempDir(), "tracking.json"), 500)
+	search := func(session int64, query string, results int) {
+		handler.HandleSearchEvent(SearchEvent{
+			BaseEvent:       &BaseEvent{Event: EVENT_SEARCH, SessionId: session},
+			Filters:         &types.Filters{},
+			NumberOfResults: results,
+			Query:           query,
+		}, nil)
+	}
+	click := func(session int64) {
+		handler.HandleEvent(Event{
+			BaseEvent: &BaseEvent{Event: EVENT_ITEM_CLICK, SessionId: session},
+			BaseItem:  &BaseItem{Id: 7},
+		}, nil)
+	}
+
+	// the first session clicks and adds to cart after reformulating
+	handler.HandleSessionEvent(Session{BaseEvent: &BaseEvent{Event: EVENT_SESSION_START, SessionId: 1}})
+	search(1, "mobil", 0)
+	search(1, "mobil", 0)
+	search(1, "telefon", 10)
+	click(1)
+	click(1)
+	handler.HandleCartEvent(CartEvent{
+		BaseEvent: &BaseEvent{Event: CART_ADD, SessionId: 1},
+		BaseItem:  &BaseItem{Id: 7, Quantity: 1},
+	}, nil)
+	// the second session gives up after reformulating
+	search(2, "tv", 10)
+	search(2, "samsung tv", 10)
+	search(2, "tv", 10)
+
+	result, err := handler.GetReformulations("", 0)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(result) != 3 {
+		t.Fatalf("Expected three reformulations, got %+v", result)
+	}
+	top := result[0]
+	if top.From != "mobil" || top.To != "telefon" || top.Kind != ReformulationSynonym {
+		t.Errorf("Expected engaged reformulation first, got %+v", top)
+	}
+	if top.Count != 1 || top.Clicks != 1 || top.Carts != 1 || top.Score < reformulationBase+reformulationClick+reformulationCart-0.01 {
+		t.Errorf("Expected one click and cart credited once, got %+v", top)
+	}
+	if result, _ := handler.GetReformulations(ReformulationRefinement, 0); len(result) != 2 {
+		t.Errorf("Expected both tv reformulations as refinements, got %+v", result)
+	}
+	if result, _ := handler.GetReformulations("", 1); len(result) != 1 {
+		t.Errorf("Expected limit to apply, got %+v", result)
+	}
+	if _, err := handler.GetReformulations("unknown", 0); err == nil {
+		t.Errorf("Expected unknown kind to be rejected")
+	}
+}
@@ -8,12 +8,16 @@ import (
 	"time"
 )
 
+const sessionMaxAge = time.Hour * 24 * 7
+
 func (session *SessionData) DecayEvents(trk PopularityListener) {
 	ts := time.Now().Unix()
 	now := ts
 
 	session.LastSync = ts
-	sf := len(session.FieldEvents)
+	session.ItemEvents.Compact(now)
+	session.FieldEvents.Compact(now)
+	sf := session.FieldEvents.Len()
 	if sf > 0 {
 		//log.Printf("Decaying field events %d", sf)
 		fieldPopularity := session.FieldEvents.Decay(now)
@@ -25,7 +29,7 @@ func (session *SessionData) DecayEvents(trk PopularityListener) {
 		}
 	}
 
-	si := len(session.ItemEvents)
+	si := session.ItemEvents.Len()
 	if si > 0 {
 		itemPopularity := session.ItemEvents.Decay(now)
 		if len(itemPopularity) > 0 {
@@ -43,7 +47,9 @@ func (p *PersonalizationGroup) DecayGroupEvents(trk PopularityListener) {
 	now := ts
 
 	p.LastSync = ts
-	sf := len(p.FieldEvents)
+	p.ItemEvents.Compact(now)
+	p.FieldEvents.Compact(now)
+	sf := p.FieldEvents.Len()
 	if sf > 0 {
 		//log.Printf("Decaying field events %d", sf)
 		fieldPopularity := p.FieldEvents.Decay(now)
@@ -55,7 +61,7 @@ func (p *PersonalizationGroup) DecayGroupEvents(trk PopularityListener) {
 		}
 	}
 
-	si := len(p.ItemEvents)
+	si := p.ItemEvents.Len()
 	if si > 0 {
 		itemPopularity := p.ItemEvents.Decay(now)
 		if len(itemPopularity) > 0 {
@@ -72,13 +78,15 @@ func (s *PersistentMemoryTrackingHandler) DecayEvents() {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	now := time.Now().Unix()
-	l := len(s.ItemEvents) + len(s.FieldEvents)
+	l := s.ItemEvents.Len() + s.FieldEvents.Len()
 	if l == 0 {
 		return
 	}
 
 	s.ItemPopularity = s.ItemEvents.Decay(now)
 	s.FieldPopularity = s.FieldEvents.Decay(now)
+	s.ItemEvents.Compact(now)
+	s.FieldEvents.Compact(now)
 
 	log.Printf("Decayed events %d", l)
 }
@@ -164,15 +172,16 @@ func (s *PersistentMemoryTrackingHandler) DecaySuggestions() {
 		return value.Popularity.Value < 0.0002
 	})
 	s.SortedQueries = result
+	s.suggestions.Store(NewSuggestionIndex(result))
 	log.Printf("Decayed suggestions %d", len(s.QueryEvents))
 }
 
 func (s *PersistentMemoryTrackingHandler) cleanSessions() {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.EmptyResults = slices.DeleteFunc(s.EmptyResults, func(i SearchEvent) bool {
-		return i.Query == ""
-	})
+	compactNoResults(s.NoResults, time.Now().Unix())
+	compactReformulations(s.Reformulations, time.Now().Unix())
+	compactJudgements(s.Judgements, time.Now().Unix())
 	for id, session := range s.Sessions {
 		session.Events = slices.DeleteFunc(session.Events, func(i interface{}) bool {
 			return i == nil
@@ -183,7 +192,12 @@ func (s *PersistentMemoryTrackingHandler) cleanSessions() {
 	}
 	log.Println("Cleaning sessions")
 
-	limit := time.Now().Add(-time.Hour * (24 * 7)).Unix()
+	orderLimit := time.Now().Add(-time.Hour * (24 * 30)).Unix()
+	maps.DeleteFunc(s.Orders, func(key string, value OrderSummary) bool {
+		return value.TimeStamp < orderLimit
+	})
+
+	limit := time.Now().Add(-sessionMaxAge).Unix()
 	maps.DeleteFunc(s.Sessions, func(key int64, value *SessionData) bool {
 		if value == nil {
 			return true
@@ -239,7 +253,7 @@ func (s *PersistentMemoryTrackingHandler) DecayFacetValuesEvents() {
 		})
 	}
 	s.FieldValueScores = result
-	log.Printf("Decayed field events %d", len(s.FieldEvents))
+	log.Printf("Decayed field events %d", s.FieldEvents.Len())
 }
 
 func (s *PersistentMemoryTrackingHandler) DecaySessionEvents() {
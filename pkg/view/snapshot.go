@@ -0,0 +1,202 @@
+package view
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const snapshotVersion = 3
+
+type snapshotData = map[string]json.RawMessage
+
+// snapshotMigrations[i] upgrades a snapshot from version i to i+1, snapshots
+// written before the version field existed are version 0
+var snapshotMigrations = []func(data snapshotData) error{
+	migrateLegacyFields,
+	migrateDecayAggregates,
+	migrateNoResults,
+}
+
+func migrateLegacyFields(data snapshotData) error {
+	delete(data, "updated_items")
+	legacy, ok := data["empty_results"]
+	if !ok {
+		return nil
+	}
+	delete(data, "empty_results")
+	if _, found := data["empty_results_v2"]; found {
+		return nil
+	}
+	var emptyResults []SearchEvent
+	if err := json.Unmarshal(legacy, &emptyResults); err != nil {
+		log.Printf("Dropping legacy empty results: %v", err)
+		return nil
+	}
+	converted, err := json.Marshal(emptyResults)
+	if err != nil {
+		return err
+	}
+	data["empty_results_v2"] = converted
+	return nil
+}
+
+// migrateDecayAggregates only marks the format change, the event arrays are
+// folded into aggregates by DecayValue and DecayPopularity when decoding
+func migrateDecayAggregates(data snapshotData) error {
+	return nil
+}
+
+// migrateNoResults aggregates the stored searches without results by their
+// normalized query
+func migrateNoResults(data snapshotData) error {
+	legacy, ok := data["empty_results_v2"]
+	if !ok {
+		return nil
+	}
+	delete(data, "empty_results_v2")
+	var emptyResults []SearchEvent
+	if err := json.Unmarshal(legacy, &emptyResults); err != nil {
+		log.Printf("Dropping legacy empty results: %v", err)
+		return nil
+	}
+	now := time.Now().Unix()
+	noResults := make(map[string]*NoResultQuery)
+	for _, event := range emptyResults {
+		query := NormalizeQuery(event.Query, event.GetCountry())
+		if classifyQuery(event.Query, query) != "" {
+			continue
+		}
+		addNoResult(noResults, query, event, now)
+	}
+	converted, err := json.Marshal(noResults)
+	if err != nil {
+		return err
+	}
+	data["no_results"] = converted
+	return nil
+}
+
+func migrateSnapshot(data snapshotData) error {
+	version := 0
+	if raw, ok := data["version"]; ok {
+		if err := json.Unmarshal(raw, &version); err != nil {
+			return err
+		}
+	}
+	if version > snapshotVersion {
+		return fmt.Errorf("snapshot version %d is newer than supported version %d", version, snapshotVersion)
+	}
+	for ; version < snapshotVersion; version++ {
+		if err := snapshotMigrations[version](data); err != nil {
+			return fmt.Errorf("migrating snapshot from version %d: %w", version, err)
+		}
+		log.Printf("Migrated snapshot from version %d to %d", version, version+1)
+	}
+	data["version"] = json.RawMessage(fmt.Sprintf("%d", snapshotVersion))
+	// missing values keep the defaults from the constructor
+	for key, value := range data {
+		if bytes.Equal(value, []byte("null")) {
+			delete(data, key)
+		}
+	}
+	return nil
+}
+
+func load(path string, result *PersistentMemoryTrackingHandler) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return readSnapshot(bufio.NewReader(file), result)
+}
+
+func readSnapshot(reader io.Reader, result *PersistentMemoryTrackingHandler) error {
+	raw, err := io.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+	var header struct {
+		Version int `json:"version"`
+	}
+	if err = json.Unmarshal(raw, &header); err != nil {
+		return err
+	}
+	// a current snapshot is decoded as is, null values are replaced by the
+	// defaults afterwards
+	if header.Version == snapshotVersion {
+		if err = json.Unmarshal(raw, result); err != nil {
+			return err
+		}
+		result.fillDefaults()
+		return nil
+	}
+	data := snapshotData{}
+	if err = json.Unmarshal(raw, &data); err != nil {
+		return err
+	}
+	if err = migrateSnapshot(data); err != nil {
+		return err
+	}
+	migrated, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(migrated, result)
+}
+
+// writeSnapshot writes to a temporary file next to the snapshot and renames it
+// into place once it is synced, a crash leaves the previous snapshot intact
+func writeSnapshot(path string, value any) error {
+	return writeSnapshotWith(path, func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(value)
+	})
+}
+
+// writeSnapshotData writes an already encoded snapshot the same way
+func writeSnapshotData(path string, data []byte) error {
+	return writeSnapshotWith(path, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+func writeSnapshotWith(path string, encode func(w io.Writer) error) error {
+	dir := filepath.Dir(path)
+	file, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := file.Name()
+	defer os.Remove(tmpPath)
+
+	writer := bufio.NewWriter(file)
+	err = encode(writer)
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err = os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
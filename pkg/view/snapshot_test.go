@@ -0,0 +1,83 @@
+package view
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadMigratesLegacySnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tracking.json")
+	legacy := `{"also_bought":null,"updated_items":[],"empty_results":[{"event":1,"query":"tvbenk","noi":0},{"event":1,"query":"TVbenk ","noi":0}],"queries":{"tv":2}}`
+	if err := os.WriteFile(path, []byte(legacy), 0644); err != nil {
+		t.Fatal(err)
+	}
+	handler := MakeMemoryTrackingHandler(path, 500)
+
+	if handler.AlsoBought == nil {
+		t.Errorf("Expected also bought to keep its default")
+	}
+	if noResult, ok := handler.NoResults["tvbenk"]; !ok || noResult.Count != 2 {
+		t.Errorf("Expected empty results to be migrated, got %+v", handler.NoResults)
+	}
+	if handler.Queries["tv"] != 2 {
+		t.Errorf("Expected queries to be loaded")
+	}
+}
+
+func TestWriteSnapshotReplacesFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tracking.json")
+	handler := MakeMemoryTrackingHandler(path, 500)
+	handler.Queries["tv"] = 1
+	if err := handler.writeFile(path); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("Expected only the snapshot in the directory, got %d files", len(entries))
+	}
+	loaded := MakeMemoryTrackingHandler(path, 500)
+	if loaded.Version != snapshotVersion || loaded.Queries["tv"] != 1 {
+		t.Errorf("Unexpected snapshot content, version %d", loaded.Version)
+	}
+}
+
+func TestFailedLoadBlocksSave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tracking.json")
+	newer := `{"version":99,"queries":{"tv":2}}`
+	if err := os.WriteFile(path, []byte(newer), 0644); err != nil {
+		t.Fatal(err)
+	}
+	handler := MakeMemoryTrackingHandler(path, 500)
+	if handler.LoadError() == nil {
+		t.Fatalf("Expected a newer snapshot to fail to load")
+	}
+	handler.Queries["radio"] = 1
+	handler.changes++
+	if err := handler.autoSave(); err == nil {
+		t.Errorf("Expected save to be refused")
+	}
+	if data, _ := os.ReadFile(path); string(data) != newer {
+		t.Errorf("Expected the snapshot to be kept, got %s", data)
+	}
+	if missing := MakeMemoryTrackingHandler(filepath.Join(t.TempDir(), "missing.json"), 500); missing.LoadError() != nil {
+		t.Errorf("Expected a missing snapshot to start empty, got %v", missing.LoadError())
+	}
+}
+
+func TestLoadCurrentSnapshotKeepsDefaults(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tracking.json")
+	current := fmt.Sprintf(`{"version":%d,"also_bought":null,"no_results":null,"queries":{"tv":2}}`, snapshotVersion)
+	if err := os.WriteFile(path, []byte(current), 0644); err != nil {
+		t.Fatal(err)
+	}
+	handler := MakeMemoryTrackingHandler(path, 500)
+	if handler.LoadError() != nil || handler.Queries["tv"] != 2 {
+		t.Fatalf("Expected the snapshot to load, got %v", handler.LoadError())
+	}
+	if handler.AlsoBought == nil || handler.NoResults == nil {
+		t.Errorf("Expected null maps to get their defaults")
+	}
+}
@@ -0,0 +1,70 @@
+package view
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+)
+
+// Storage persists the tracking state, Snapshot takes the lock it needs on the
+// handler to capture the state and returns the write that runs without it
+type Storage interface {
+	Load(s *PersistentMemoryTrackingHandler) error
+	Snapshot(s *PersistentMemoryTrackingHandler) (func() error, error)
+	Close() error
+}
+
+// SessionStorage is implemented by storages that keep sessions outside of the
+// snapshot, only recently active sessions are kept in memory
+type SessionStorage interface {
+	Storage
+	LoadSession(sessionId int64) (*SessionData, error)
+}
+
+type JsonFileStorage struct {
+	path string
+}
+
+func NewJsonFileStorage(path string) *JsonFileStorage {
+	return &JsonFileStorage{
+		path: path,
+	}
+}
+
+// Load starts from an empty state when there is no snapshot yet
+func (j *JsonFileStorage) Load(s *PersistentMemoryTrackingHandler) error {
+	err := load(j.path, s)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (j *JsonFileStorage) Snapshot(s *PersistentMemoryTrackingHandler) (func() error, error) {
+	s.mu.RLock()
+	data, err := json.Marshal(s)
+	s.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	return func() error {
+		return writeSnapshotData(j.path, data)
+	}, nil
+}
+
+func (j *JsonFileStorage) Save(s *PersistentMemoryTrackingHandler) error {
+	return saveSnapshot(j, s)
+}
+
+// saveSnapshot captures and writes the state in one go
+func saveSnapshot(storage Storage, s *PersistentMemoryTrackingHandler) error {
+	write, err := storage.Snapshot(s)
+	if err != nil {
+		return err
+	}
+	return write()
+}
+
+func (j *JsonFileStorage) Close() error {
+	return nil
+}
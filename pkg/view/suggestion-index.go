@@ -0,0 +1,266 @@
+package view
+
+import (
+	"cmp"
+	"math"
+	"slices"
+	"strings"
+)
+
+const (
+	// completions kept per prefix, also the largest limit a lookup can use
+	suggestionTopK      = 50
+	suggestionFacets    = 3
+	suggestionFacetVals = 5
+	// corrected matches are only looked for from this prefix length and never
+	// fill more than suggestionFuzzyMax places
+	suggestionFuzzyMinLength  = 3
+	suggestionFuzzyMax        = 10
+	suggestionFuzzyCandidates = 200
+)
+
+type Suggestion struct {
+	QueryResult
+	Corrected bool `json:"corrected"`
+}
+
+type trieNode struct {
+	children map[rune]*trieNode
+	top      []int32
+}
+
+func (n *trieNode) child(r rune) *trieNode {
+	if n.children == nil {
+		n.children = make(map[rune]*trieNode)
+	}
+	c, ok := n.children[r]
+	if !ok {
+		c = &trieNode{}
+		n.children[r] = c
+	}
+	return c
+}
+
+// SuggestionIndex finds the best scored queries for a prefix, every word in a
+// query starts a key so a prefix also matches later words in the query.
+// Prefixes with typos are matched through the trigrams of the queries
+type SuggestionIndex struct {
+	root     *trieNode
+	results  []QueryResult
+	keys     [][][]rune
+	trigrams map[string][]int32
+}
+
+func topFacets(facets []FacetResult) []FacetResult {
+	facets = facets[:min(len(facets), suggestionFacets)]
+	result := make([]FacetResult, len(facets))
+	for i, facet := range facets {
+		result[i] = FacetResult{
+			FacetId: facet.FacetId,
+			Score:   facet.Score,
+			Values:  facet.Values[:min(len(facet.Values), suggestionFacetVals)],
+		}
+	}
+	return result
+}
+
+// NewSuggestionIndex expects the queries sorted by score, best first
+func NewSuggestionIndex(sorted []QueryResult) *SuggestionIndex {
+	index := &SuggestionIndex{
+		root:     &trieNode{},
+		results:  make([]QueryResult, len(sorted)),
+		keys:     make([][][]rune, len(sorted)),
+		trigrams: make(map[string][]int32),
+	}
+	for i, query := range sorted {
+		index.results[i] = QueryResult{
+			Query:  query.Query,
+			Score:  query.Score,
+			Facets: topFacets(query.Facets),
+		}
+		key := strings.ToLower(query.Query)
+		index.insert(key, int32(i))
+		index.addKey(key, int32(i))
+		for j, r := range key {
+			if r == ' ' {
+				index.insert(key[j+1:], int32(i))
+				index.addKey(key[j+1:], int32(i))
+			}
+		}
+	}
+	return index
+}
+
+// trigrams marks the start of the key so the first letters weigh more
+func trigrams(key []rune) []string {
+	padded := append([]rune{'^'}, key...)
+	result := make([]string, 0, len(padded))
+	for i := 0; i+3 <= len(padded); i++ {
+		result = append(result, string(padded[i:i+3]))
+	}
+	return result
+}
+
+func (idx *SuggestionIndex) addKey(key string, i int32) {
+	if key == "" {
+		return
+	}
+	runes := []rune(key)
+	idx.keys[i] = append(idx.keys[i], runes)
+	for _, trigram := range trigrams(runes) {
+		list := idx.trigrams[trigram]
+		if len(list) == 0 || list[len(list)-1] != i {
+			idx.trigrams[trigram] = append(list, i)
+		}
+	}
+}
+
+func (idx *SuggestionIndex) insert(key string, i int32) {
+	node := idx.root
+	for _, r := range key {
+		node = node.child(r)
+		// queries are inserted best first, so the list stays sorted
+		if len(node.top) < suggestionTopK && (len(node.top) == 0 || node.top[len(node.top)-1] != i) {
+			node.top = append(node.top, i)
+		}
+	}
+}
+
+// Lookup returns at most limit completions for the prefix, best first, places
+// left over are filled with corrected matches
+func (idx *SuggestionIndex) Lookup(prefix string, limit int) []Suggestion {
+	if limit <= 0 || limit > suggestionTopK {
+		limit = suggestionTopK
+	}
+	prefix = strings.ToLower(strings.TrimSpace(prefix))
+	top := idx.prefixMatches(prefix)
+	top = top[:min(limit, len(top))]
+	result := make([]Suggestion, 0, limit)
+	for _, j := range top {
+		result = append(result, Suggestion{QueryResult: idx.results[j]})
+	}
+	if len(result) < limit && len([]rune(prefix)) >= suggestionFuzzyMinLength {
+		fuzzy := idx.fuzzyMatches([]rune(prefix), top)
+		for _, j := range fuzzy[:min(limit-len(result), len(fuzzy), suggestionFuzzyMax)] {
+			result = append(result, Suggestion{QueryResult: idx.results[j], Corrected: true})
+		}
+	}
+	return result
+}
+
+func (idx *SuggestionIndex) prefixMatches(prefix string) []int32 {
+	if prefix == "" {
+		result := make([]int32, min(suggestionTopK, len(idx.results)))
+		for i := range result {
+			result[i] = int32(i)
+		}
+		return result
+	}
+	node := idx.root
+	for _, r := range prefix {
+		next, ok := node.children[r]
+		if !ok {
+			return []int32{}
+		}
+		node = next
+	}
+	return node.top
+}
+
+// maxEdits allows one typo in short prefixes and two in longer ones
+func maxEdits(length int) int {
+	if length < 6 {
+		return 1
+	}
+	return 2
+}
+
+type fuzzyMatch struct {
+	index int32
+	rank  float64
+}
+
+func (idx *SuggestionIndex) fuzzyMatches(prefix []rune, exclude []int32) []int32 {
+	edits := maxEdits(len(prefix))
+	shared := make(map[int32]int)
+	for _, trigram := range trigrams(prefix) {
+		for _, i := range idx.trigrams[trigram] {
+			shared[i]++
+		}
+	}
+	// every edit changes at most three trigrams
+	required := max(1, len(trigrams(prefix))-3*edits)
+	candidates := make([]int32, 0, len(shared))
+	for i, count := range shared {
+		if count >= required && !slices.Contains(exclude, i) {
+			candidates = append(candidates, i)
+		}
+	}
+	slices.SortFunc(candidates, func(a, b int32) int {
+		if c := cmp.Compare(shared[b], shared[a]); c != 0 {
+			return c
+		}
+		return cmp.Compare(a, b)
+	})
+	candidates = candidates[:min(len(candidates), suggestionFuzzyCandidates)]
+
+	matches := make([]fuzzyMatch, 0, len(candidates))
+	for _, i := range candidates {
+		distance := edits + 1
+		for _, key := range idx.keys[i] {
+			distance = min(distance, prefixDistance(prefix, key))
+		}
+		if distance > edits {
+			continue
+		}
+		similarity := 1 - float64(distance)/float64(len(prefix))
+		matches = append(matches, fuzzyMatch{
+			index: i,
+			rank:  similarity * math.Log1p(max(idx.results[i].Score, 0)),
+		})
+	}
+	slices.SortFunc(matches, func(a, b fuzzyMatch) int {
+		return cmp.Compare(b.rank, a.rank)
+	})
+	result := make([]int32, len(matches))
+	for i, match := range matches {
+		result[i] = match.index
+	}
+	return result
+}
+
+// prefixDistance is the optimal string alignment distance between the prefix
+// and the closest prefix of the key, swapped letters count as one edit
+func prefixDistance(prefix []rune, key []rune) int {
+	rows := alignmentRows(prefix, key)
+	return slices.Min(rows[len(prefix)])
+}
+
+// editDistance is the optimal string alignment distance between a and b
+func editDistance(a []rune, b []rune) int {
+	return alignmentRows(a, b)[len(a)][len(b)]
+}
+
+func alignmentRows(prefix []rune, key []rune) [][]int {
+	rows := make([][]int, len(prefix)+1)
+	for i := range rows {
+		rows[i] = make([]int, len(key)+1)
+		rows[i][0] = i
+	}
+	for j := range rows[0] {
+		rows[0][j] = j
+	}
+	for i := 1; i <= len(prefix); i++ {
+		for j := 1; j <= len(key); j++ {
+			cost := 1
+			if prefix[i-1] == key[j-1] {
+				cost = 0
+			}
+			rows[i][j] = min(rows[i-1][j]+1, rows[i][j-1]+1, rows[i-1][j-1]+cost)
+			if i > 1 && j > 1 && prefix[i-1] == key[j-2] && prefix[i-2] == key[j-1] {
+				rows[i][j] = min(rows[i][j], rows[i-2][j-2]+1)
+			}
+		}
+	}
+	return rows
+}
@@ -0,0 +1,89 @@
+package view
+
+import (
+	"testing"
+)
+
+func TestSuggestionIndexLookup(t *testing.T) {
+	index := NewSuggestionIndex([]QueryResult{
+		{Query: "samsung tv", Score: 30, Facets: []FacetResult{{FacetId: 1}, {FacetId: 2}, {FacetId: 3}, {FacetId: 4}}},
+		{Query: "tvbenk", Score: 20},
+		{Query: "tv tv", Score: 10},
+		{Query: "iphone", Score: 5},
+	})
+
+	result := index.Lookup("TV", 10)
+	if len(result) != 3 || result[0].Query != "samsung tv" || result[1].Query != "tvbenk" || result[2].Query != "tv tv" {
+		t.Errorf("Unexpected completions %+v", result)
+	}
+	if len(result[0].Facets) != suggestionFacets {
+		t.Errorf("Expected top %d facets, got %d", suggestionFacets, len(result[0].Facets))
+	}
+	if result := index.Lookup("tv", 1); len(result) != 1 || result[0].Query != "samsung tv" {
+		t.Errorf("Expected limit to keep the best completion, got %+v", result)
+	}
+	if result := index.Lookup("sams", 0); len(result) != 1 {
+		t.Errorf("Expected prefix match, got %+v", result)
+	}
+	if result := index.Lookup("xbox", 10); len(result) != 0 {
+		t.Errorf("Expected no completions, got %+v", result)
+	}
+	if result := index.Lookup("", 2); len(result) != 2 {
+		t.Errorf("Expected top queries for empty prefix, got %+v", result)
+	}
+}
+
+func TestSuggestionIndexCorrectsTypos(t *testing.T) {
+	index := NewSuggestionIndex([]QueryResult{
+		{Query: "iphone case", Score: 40},
+		{Query: "iphone", Score: 30},
+		{Query: "playstation 5", Score: 20},
+		{Query: "sony playstation", Score: 10},
+		{Query: "phone holder", Score: 5},
+	})
+
+	result := index.Lookup("iphnoe", 10)
+	if len(result) != 2 || result[0].Query != "iphone case" || result[1].Query != "iphone" {
+		t.Errorf("Expected corrected iphone queries, got %+v", result)
+	}
+	for _, suggestion := range result {
+		if !suggestion.Corrected {
+			t.Errorf("Expected %s to be marked as corrected", suggestion.Query)
+		}
+	}
+	if result := index.Lookup("playstaion", 1); len(result) != 1 || result[0].Query != "playstation 5" {
+		t.Errorf("Expected limit to keep the most popular correction, got %+v", result)
+	}
+	if result := index.Lookup("playstaion", 10); len(result) != 2 || result[1].Query != "sony playstation" {
+		t.Errorf("Expected typo to match later words, got %+v", result)
+	}
+	result = index.Lookup("iphone", 10)
+	if len(result) != 3 || result[0].Corrected || result[1].Corrected {
+		t.Errorf("Expected exact prefix matches first and not corrected, got %+v", result)
+	}
+	if result[2].Query != "phone holder" || !result[2].Corrected {
+		t.Errorf("Expected corrections to fill the remaining places, got %+v", result)
+	}
+	if result := index.Lookup("xqzw", 10); len(result) != 0 {
+		t.Errorf("Expected no corrections, got %+v", result)
+	}
+}
+
+func TestPrefixDistance(t *testing.T) {
+	tests := []struct {
+		prefix   string
+		key      string
+		expected int
+	}{
+		{"iphone", "iphone case", 0},
+		{"iphnoe", "iphone", 1},
+		{"iphoen", "iphone", 1},
+		{"playstaion", "playstation 5", 1},
+		{"xbox", "iphone", 4},
+	}
+	for _, test := range tests {
+		if distance := prefixDistance([]rune(test.prefix), []rune(test.key)); distance != test.expected {
+			t.Errorf("Expected distance %d between %s and %s, got %d", test.expected, test.prefix, test.key, distance)
+		}
+	}
+}
@@ -1,15 +1,15 @@
 package view
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"maps"
 	"math/rand/v2"
 	"net/http"
-	"os"
 	"runtime"
-	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/matst80/slask-finder/pkg/sorting"
@@ -24,6 +24,7 @@ type TrackingHandler interface {
 	HandleCartEvent(event CartEvent, r *http.Request)
 	HandleDataSetEvent(event DataSetEvent, r *http.Request)
 	HandleEnterCheckout(event EnterCheckoutEvent, r *http.Request)
+	HandlePurchaseEvent(event PurchaseEvent, r *http.Request) error
 	HandleImpressionEvent(event ImpressionEvent, r *http.Request)
 	HandleActionEvent(event ActionEvent, r *http.Request)
 	HandleSuggestEvent(event SuggestEvent, r *http.Request)
@@ -53,65 +54,110 @@ type QueryKeyData struct {
 }
 
 type QueryMatcher struct {
+	Profile    string           `json:"profile,omitempty"`
 	Popularity *DecayPopularity `json:"popularity"`
 	//	Query      string                `json:"query"`
 	KeyFields map[uint]QueryKeyData `json:"keyFacets"`
 }
 
-func (q *QueryMatcher) AddKeyFilterEvent(key uint, value string) {
-	ts := time.Now().Unix()
+// Bind makes the query and all its facet popularities decay with the named
+// profile
+func (q *QueryMatcher) Bind(profile string) {
+	q.Profile = profile
+	if q.Popularity != nil {
+		q.Popularity.Profile = profile
+	}
+	for _, keyField := range q.KeyFields {
+		if keyField.FieldPopularity != nil {
+			keyField.FieldPopularity.Profile = profile
+		}
+		for _, value := range keyField.ValuePopularity {
+			value.Profile = profile
+		}
+	}
+}
+
+func (q *QueryMatcher) AddKeyFilterEvent(key uint, value string, ts int64) {
+	weight := GetEventWeights().Global.QueryFacet.Value(0, 0)
 	popularity, ok := q.KeyFields[key]
 	if !ok {
 		popularity = QueryKeyData{
-			FieldPopularity: &DecayPopularity{},
+			FieldPopularity: NewDecayPopularity(q.Profile),
 			ValuePopularity: make(map[string]*DecayPopularity),
 		}
 		q.KeyFields[key] = popularity
 	}
 	popularity.FieldPopularity.Add(DecayEvent{
 		TimeStamp: ts,
-		Value:     100,
+		Value:     weight,
 	})
 	if value != "" {
 		valuePopularity, ok := popularity.ValuePopularity[value]
 		if !ok {
-			valuePopularity = &DecayPopularity{}
+			valuePopularity = NewDecayPopularity(q.Profile)
 			popularity.ValuePopularity[value] = valuePopularity
 		}
 		valuePopularity.Add(DecayEvent{
 			TimeStamp: ts,
-			Value:     100,
+			Value:     weight,
 		})
 	}
 
 }
 
+var ErrDuplicateOrder = errors.New("order already tracked")
+
+type OrderSummary struct {
+	SessionId int64   `json:"session_id,omitempty"`
+	TimeStamp int64   `json:"ts"`
+	Revenue   float64 `json:"revenue,omitempty"`
+	Currency  string  `json:"currency,omitempty"`
+	Items     int     `json:"items"`
+}
+
 type ProductRelation struct {
 	ItemId uint               `json:"item_id"`
 	Other  map[uint]DecayList `json:"other"`
 }
 
 type PersistentMemoryTrackingHandler struct {
-	path                  string
+	storage               Storage
 	mu                    sync.RWMutex
 	changes               uint
 	updatesToKeep         int
 	trackingHandler       PopularityListener
+	saveHandler           func() error
+	loadErr               error
+	replaying             bool
+	suggestions           atomic.Pointer[SuggestionIndex]
+	changedQueryItems     map[string]struct{}
+	Version               int                                  `json:"version"`
+	LogSequence           uint64                               `json:"log_sequence"`
 	ViewedTogether        map[uint]ProductRelation             `json:"viewed_together"`
 	AlsoBought            map[uint]ProductRelation             `json:"also_bought"`
+	Orders                map[string]OrderSummary              `json:"orders"`
 	DataSet               []DataSetEvent                       `json:"dataset"`
 	FieldValueScores      map[uint][]FacetValueResult          `json:"field_value_scores"`
 	ItemPopularity        sorting.SortOverride                 `json:"item_popularity"`
 	Queries               map[string]uint                      `json:"queries"`
+	QueryCountries        map[string]string                    `json:"query_countries"`
 	QueryEvents           map[string]QueryMatcher              `json:"suggestions"`
-	Sessions              map[int64]*SessionData               `json:"sessions"`
+	Sessions              map[int64]*SessionData               `json:"sessions,omitempty"`
 	FieldPopularity       sorting.SortOverride                 `json:"field_popularity"`
 	ItemEvents            DecayList                            `json:"item_events"`
 	FieldEvents           DecayList                            `json:"field_events"`
+	Windows               map[string]*PopularityWindow         `json:"windows"`
+	ClickModel            *ClickModel                          `json:"click_model"`
+	ItemStats             *ItemStats                           `json:"item_stats"`
 	SortedQueries         []QueryResult                        `json:"sorted_queries"`
 	FieldValueEvents      map[uint]map[string]*DecayPopularity `json:"field_value_events"`
 	Funnels               []Funnel                             `json:"funnel_storage"`
-	EmptyResults          []SearchEvent                        `json:"empty_results_v2"`
+	NoResults             map[string]*NoResultQuery            `json:"no_results"`
+	JunkQueries           map[string]*JunkQuery                `json:"junk_queries"`
+	JunkTotals            map[string]uint                      `json:"junk_totals"`
+	Reformulations        map[string]map[string]*Reformulation `json:"reformulations"`
+	QueryItems            map[string]*DecayList                `json:"query_items"`
+	Judgements            map[string]*QueryJudgements          `json:"judgements"`
 	PersonalizationGroups map[string]PersonalizationGroup      `json:"personalization_groups"`
 	//UpdatedItems    []interface{}        `json:"updated_items"`
 }
@@ -123,13 +169,14 @@ type SessionData struct {
 	Variations  map[string]interface{} `json:"variations"`
 	// ItemPopularity  index.SortOverride     `json:"item_popularity"`
 	// FieldPopularity index.SortOverride     `json:"field_popularity"`
-	Id          int64         `json:"id"`
-	Events      []interface{} `json:"events"`
-	ItemEvents  DecayList     `json:"item_events"`
-	FieldEvents DecayList     `json:"field_events"`
-	Created     int64         `json:"ts"`
-	LastUpdate  int64         `json:"last_update"`
-	LastSync    int64         `json:"last_sync"`
+	Id          int64          `json:"id"`
+	Events      []interface{}  `json:"events"`
+	LastSearch  *SessionSearch `json:"last_search,omitempty"`
+	ItemEvents  DecayList      `json:"item_events"`
+	FieldEvents DecayList      `json:"field_events"`
+	Created     int64          `json:"ts"`
+	LastUpdate  int64          `json:"last_update"`
+	LastSync    int64          `json:"last_sync"`
 }
 
 func (session *SessionData) HandleVariation(id string) (interface{}, error) {
@@ -155,13 +202,9 @@ const (
 	eventLimit = 500
 )
 
-func (session *SessionData) HandleEvent(event interface{}) map[string]float64 {
-	if session.ItemEvents == nil {
-		session.ItemEvents = make(map[uint][]DecayEvent)
-	}
-	if session.FieldEvents == nil {
-		session.FieldEvents = make(map[uint][]DecayEvent)
-	}
+func (session *SessionData) HandleEvent(event interface{}, now int64) map[string]float64 {
+	session.ItemEvents.Bind(ProfileItem)
+	session.FieldEvents.Bind(ProfileField)
 	if session.VisitedSkus == nil {
 		session.VisitedSkus = make([]uint, 0)
 	}
@@ -173,8 +216,7 @@ func (session *SessionData) HandleEvent(event interface{}) map[string]float64 {
 		session.Groups = make(map[string]float64)
 	}
 
-	ts := time.Now().Unix()
-	now := ts
+	weights := GetEventWeights().Session
 	session.Events = append(session.Events, event)
 	session.LastUpdate = now
 	switch e := event.(type) {
@@ -182,7 +224,7 @@ func (session *SessionData) HandleEvent(event interface{}) map[string]float64 {
 		if e.BaseItem != nil && e.Id > 0 {
 			session.ItemEvents.Add(e.Id, DecayEvent{
 				TimeStamp: now,
-				Value:     200,
+				Value:     weights.Click.Value(e.Quantity, e.Position),
 			})
 			if e.BaseItem.Category == "Gaming" {
 				session.Groups["gamer"] += 5
@@ -199,13 +241,13 @@ func (session *SessionData) HandleEvent(event interface{}) map[string]float64 {
 		for _, filter := range e.Filters.StringFilter {
 			session.FieldEvents.Add(filter.Id, DecayEvent{
 				TimeStamp: now,
-				Value:     150,
+				Value:     weights.Filter.Value(0, 0),
 			})
 		}
 		for _, filter := range e.Filters.RangeFilter {
 			session.FieldEvents.Add(filter.Id, DecayEvent{
 				TimeStamp: now,
-				Value:     100,
+				Value:     weights.RangeFilter.Value(0, 0),
 			})
 		}
 
@@ -213,22 +255,24 @@ func (session *SessionData) HandleEvent(event interface{}) map[string]float64 {
 		for _, impression := range e.Items {
 			session.ItemEvents.Add(impression.Id, DecayEvent{
 				TimeStamp: now,
-				Value:     10 + (0.02 * float64(max(impression.Position, 300))),
+				Value:     weights.Impression.Value(impression.Quantity, impression.Position),
 			})
 			session.VisitedSkus = append(session.VisitedSkus, impression.Id)
 		}
 
 	case CartEvent:
-		session.ItemEvents.Add(e.Id, DecayEvent{
-			TimeStamp: now,
-			Value:     700,
-		})
+		if e.BaseItem != nil && e.Id > 0 {
+			session.ItemEvents.Add(e.Id, DecayEvent{
+				TimeStamp: now,
+				Value:     weights.Cart.Value(e.Quantity, e.Position),
+			})
+		}
 
 	case ActionEvent:
 		if e.BaseItem != nil && e.Id > 0 {
 			session.ItemEvents.Add(e.Id, DecayEvent{
 				TimeStamp: now,
-				Value:     80,
+				Value:     weights.Action.Value(e.Quantity, e.Position),
 			})
 		}
 
@@ -236,7 +280,7 @@ func (session *SessionData) HandleEvent(event interface{}) map[string]float64 {
 		for _, purchase := range e.Items {
 			session.ItemEvents.Add(purchase.Id, DecayEvent{
 				TimeStamp: now,
-				Value:     800 * float64(purchase.Quantity),
+				Value:     weights.Purchase.Value(purchase.Quantity, purchase.Position),
 			})
 		}
 
@@ -267,25 +311,57 @@ func (s *PersistentMemoryTrackingHandler) ConnectPopularityListener(handler Popu
 	s.trackingHandler = handler
 }
 
+// SetReplaying makes the handlers use the timestamps on the events, replayed
+// events happened before the restart and not now
+func (s *PersistentMemoryTrackingHandler) SetReplaying(replaying bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.replaying = replaying
+}
+
+// eventTime is when the event is counted, the time on the event while
+// replaying and now otherwise
+func (s *PersistentMemoryTrackingHandler) eventTime(event *BaseEvent) int64 {
+	if s.replaying && event != nil && event.TimeStamp > 0 {
+		return event.TimeStamp
+	}
+	return time.Now().Unix()
+}
+
 func MakeMemoryTrackingHandler(path string, itemsToKeep int) *PersistentMemoryTrackingHandler {
+	return MakeTrackingHandlerWithStorage(NewJsonFileStorage(path), itemsToKeep)
+}
+
+func MakeTrackingHandlerWithStorage(storage Storage, itemsToKeep int) *PersistentMemoryTrackingHandler {
 
 	instance := &PersistentMemoryTrackingHandler{
-		path:             "data",
+		storage:          storage,
+		Version:          snapshotVersion,
 		mu:               sync.RWMutex{},
 		changes:          0,
 		updatesToKeep:    0,
 		trackingHandler:  nil,
 		ViewedTogether:   make(map[uint]ProductRelation),
 		AlsoBought:       make(map[uint]ProductRelation),
+		Orders:           make(map[string]OrderSummary),
 		DataSet:          make([]DataSetEvent, 0),
-		EmptyResults:     make([]SearchEvent, 0),
+		NoResults:        make(map[string]*NoResultQuery),
+		JunkQueries:      make(map[string]*JunkQuery),
+		JunkTotals:       make(map[string]uint),
+		Reformulations:   make(map[string]map[string]*Reformulation),
+		QueryItems:       make(map[string]*DecayList),
+		Judgements:       make(map[string]*QueryJudgements),
 		QueryEvents:      make(map[string]QueryMatcher),
 		ItemPopularity:   make(sorting.SortOverride),
 		Queries:          make(map[string]uint),
+		QueryCountries:   make(map[string]string),
 		Sessions:         make(map[int64]*SessionData),
 		FieldPopularity:  make(sorting.SortOverride),
-		ItemEvents:       map[uint][]DecayEvent{},
-		FieldEvents:      map[uint][]DecayEvent{},
+		ItemEvents:       NewDecayList(ProfileItem),
+		FieldEvents:      NewDecayList(ProfileField),
+		Windows:          make(map[string]*PopularityWindow),
+		ClickModel:       NewClickModel(),
+		ItemStats:        NewItemStats(),
 		FieldValueEvents: make(map[uint]map[string]*DecayPopularity),
 		Funnels:          make([]Funnel, 0),
 		SortedQueries:    make([]QueryResult, 0),
@@ -294,34 +370,41 @@ func MakeMemoryTrackingHandler(path string, itemsToKeep int) *PersistentMemoryTr
 			"gamer": {
 				Id:          "gamer",
 				Name:        "Gamer",
-				ItemEvents:  make(map[uint][]DecayEvent),
-				FieldEvents: make(map[uint][]DecayEvent),
+				ItemEvents:  NewDecayList(ProfileItem),
+				FieldEvents: NewDecayList(ProfileField),
 			},
 			"tv": {
 				Id:          "tv",
 				Name:        "TV",
-				ItemEvents:  make(map[uint][]DecayEvent),
-				FieldEvents: make(map[uint][]DecayEvent),
+				ItemEvents:  NewDecayList(ProfileItem),
+				FieldEvents: NewDecayList(ProfileField),
 			},
 			"apple": {
 				Id:          "apple",
 				Name:        "Apple",
-				ItemEvents:  make(map[uint][]DecayEvent),
-				FieldEvents: make(map[uint][]DecayEvent),
+				ItemEvents:  NewDecayList(ProfileItem),
+				FieldEvents: NewDecayList(ProfileField),
 			},
 		},
 		//UpdatedItems:    make([]interface{}, 0),
 	}
 
-	err := load(path, instance)
+	err := storage.Load(instance)
 
 	if err != nil {
-		log.Printf("Error loading tracking data: %s", err)
+		// saving would replace the snapshot that failed to load with an
+		// empty state
+		log.Printf("Error loading tracking data, saving is disabled: %s", err)
+		instance.loadErr = err
 	}
+	instance.bindDecayProfiles()
+	instance.renormalizeQueries()
+	instance.separateJunkQueries()
+	instance.suggestions.Store(NewSuggestionIndex(instance.SortedQueries))
 	go func() {
 		for range time.Tick(time.Minute) {
 			if instance.changes > 0 {
-				err := instance.save()
+				err := instance.autoSave()
 				if err != nil {
 					log.Println(err)
 				}
@@ -329,18 +412,194 @@ func MakeMemoryTrackingHandler(path string, itemsToKeep int) *PersistentMemoryTr
 		}
 	}()
 
-	instance.path = path
 	instance.changes = 0
 	instance.updatesToKeep = itemsToKeep
 
 	return instance
 }
 
+// bindDecayProfiles binds the loaded decay lists to their profiles and
+// creates the models missing from older snapshots
+func (s *PersistentMemoryTrackingHandler) bindDecayProfiles() {
+	s.ItemEvents.Bind(ProfileItem)
+	s.FieldEvents.Bind(ProfileField)
+	s.ensureWindows()
+	if s.ClickModel == nil {
+		s.ClickModel = NewClickModel()
+	}
+	s.ClickModel.bind()
+	if s.ItemStats == nil {
+		s.ItemStats = NewItemStats()
+	}
+	s.ItemStats.bind()
+	s.bindQueryItems()
+	for _, session := range s.Sessions {
+		session.ItemEvents.Bind(ProfileItem)
+		session.FieldEvents.Bind(ProfileField)
+	}
+	for id, group := range s.PersonalizationGroups {
+		group.ItemEvents.Bind(ProfileItem)
+		group.FieldEvents.Bind(ProfileField)
+		s.PersonalizationGroups[id] = group
+	}
+	for _, relations := range []map[uint]ProductRelation{s.ViewedTogether, s.AlsoBought} {
+		for _, relation := range relations {
+			for id, list := range relation.Other {
+				list.Bind(ProfileRelation)
+				relation.Other[id] = list
+			}
+		}
+	}
+	for query, matcher := range s.QueryEvents {
+		if matcher.Profile == "" {
+			matcher.Bind(ProfileQuery)
+			s.QueryEvents[query] = matcher
+		}
+	}
+	for _, values := range s.FieldValueEvents {
+		for _, popularity := range values {
+			if popularity.Profile == "" {
+				popularity.Profile = ProfileFacet
+			}
+		}
+	}
+}
+
+// ConnectSaveHandler replaces the periodic and manual saves, used when the
+// snapshot has to be coordinated with an event log
+func (s *PersistentMemoryTrackingHandler) ConnectSaveHandler(handler func() error) {
+	s.saveHandler = handler
+}
+
+func (s *PersistentMemoryTrackingHandler) autoSave() error {
+	if s.saveHandler != nil {
+		return s.saveHandler()
+	}
+	return s.save()
+}
+
 func (s *PersistentMemoryTrackingHandler) Save() {
-	s.save()
+	if err := s.autoSave(); err != nil {
+		log.Println(err)
+	}
+}
+
+// SaveSnapshot writes the state with the sequence of the last event log
+// record that has been applied to it
+func (s *PersistentMemoryTrackingHandler) SaveSnapshot(sequence uint64) error {
+	write, err := s.PrepareSnapshot(sequence)
+	if err != nil || write == nil {
+		return err
+	}
+	return write()
+}
+
+// PrepareSnapshot captures the state at the sequence and returns the write,
+// which can run while new events are handled, the write is nil when nothing
+// changed since the last snapshot
+func (s *PersistentMemoryTrackingHandler) PrepareSnapshot(sequence uint64) (func() error, error) {
+	s.mu.Lock()
+	if s.LogSequence != sequence {
+		s.LogSequence = sequence
+		s.changes++
+	}
+	s.mu.Unlock()
+	return s.prepareSave()
+}
+
+func (s *PersistentMemoryTrackingHandler) GetLogSequence() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.LogSequence
+}
+
+// LoadError is the error from loading the snapshot, the handler does not
+// save when it is set
+func (s *PersistentMemoryTrackingHandler) LoadError() error {
+	return s.loadErr
+}
+
+// fillDefaults replaces the maps that were null in the snapshot
+func (s *PersistentMemoryTrackingHandler) fillDefaults() {
+	if s.ViewedTogether == nil {
+		s.ViewedTogether = make(map[uint]ProductRelation)
+	}
+	if s.AlsoBought == nil {
+		s.AlsoBought = make(map[uint]ProductRelation)
+	}
+	if s.Orders == nil {
+		s.Orders = make(map[string]OrderSummary)
+	}
+	if s.FieldValueScores == nil {
+		s.FieldValueScores = make(map[uint][]FacetValueResult)
+	}
+	if s.ItemPopularity == nil {
+		s.ItemPopularity = make(sorting.SortOverride)
+	}
+	if s.FieldPopularity == nil {
+		s.FieldPopularity = make(sorting.SortOverride)
+	}
+	if s.Queries == nil {
+		s.Queries = make(map[string]uint)
+	}
+	if s.QueryEvents == nil {
+		s.QueryEvents = make(map[string]QueryMatcher)
+	}
+	if s.QueryCountries == nil {
+		s.QueryCountries = make(map[string]string)
+	}
+	if s.Sessions == nil {
+		s.Sessions = make(map[int64]*SessionData)
+	}
+	if s.Windows == nil {
+		s.Windows = make(map[string]*PopularityWindow)
+	}
+	if s.ClickModel == nil {
+		s.ClickModel = NewClickModel()
+	}
+	if s.ItemStats == nil {
+		s.ItemStats = NewItemStats()
+	}
+	if s.FieldValueEvents == nil {
+		s.FieldValueEvents = make(map[uint]map[string]*DecayPopularity)
+	}
+	if s.NoResults == nil {
+		s.NoResults = make(map[string]*NoResultQuery)
+	}
+	if s.JunkQueries == nil {
+		s.JunkQueries = make(map[string]*JunkQuery)
+	}
+	if s.JunkTotals == nil {
+		s.JunkTotals = make(map[string]uint)
+	}
+	if s.Reformulations == nil {
+		s.Reformulations = make(map[string]map[string]*Reformulation)
+	}
+	if s.QueryItems == nil {
+		s.QueryItems = make(map[string]*DecayList)
+	}
+	if s.Judgements == nil {
+		s.Judgements = make(map[string]*QueryJudgements)
+	}
+	if s.PersonalizationGroups == nil {
+		s.PersonalizationGroups = make(map[string]PersonalizationGroup)
+	}
 }
 
 func (s *PersistentMemoryTrackingHandler) save() error {
+	write, err := s.prepareSave()
+	if err != nil || write == nil {
+		return err
+	}
+	return write()
+}
+
+// prepareSave decays the state and captures it from the storage, the write is
+// nil when nothing changed
+func (s *PersistentMemoryTrackingHandler) prepareSave() (func() error, error) {
+	if s.loadErr != nil {
+		return nil, fmt.Errorf("not saving over the snapshot that failed to load: %w", s.loadErr)
+	}
 	s.DecaySuggestions()
 	s.DecayEvents()
 
@@ -348,39 +607,31 @@ func (s *PersistentMemoryTrackingHandler) save() error {
 	s.cleanSessions()
 	s.DecayFacetValuesEvents()
 
-	defer runtime.GC()
 	if s.changes == 0 {
-		return nil
+		return nil, nil
 	}
 	if s.trackingHandler != nil {
 		go s.trackingHandler.PopularityChanged(&s.ItemPopularity)
 		go s.trackingHandler.FieldPopularityChanged(&s.FieldPopularity)
 	}
+	s.DecayWindows()
+	go s.PublishTrending()
+	s.DecayClickModel()
+	s.DecayItemStats()
+	s.DecayQueryItems()
+	s.pruneQueryCountries()
 
 	log.Println("Saving tracking data")
 
 	s.changes = 0
-	err := s.writeFile(s.path)
-
-	return err
-}
-
-func load(path string, result *PersistentMemoryTrackingHandler) error {
-	file, err := os.Open(path)
+	write, err := s.storage.Snapshot(s)
 	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	err = json.NewDecoder(file).Decode(result)
-	// tmp since the fields does not exist in the json
-	if result.ViewedTogether == nil {
-		result.ViewedTogether = make(map[uint]ProductRelation)
+		return nil, err
 	}
-	if result.AlsoBought == nil {
-		result.AlsoBought = make(map[uint]ProductRelation)
-	}
-	return err
+	return func() error {
+		defer runtime.GC()
+		return write()
+	}, nil
 }
 
 func (s *PersistentMemoryTrackingHandler) Clear() {
@@ -389,9 +640,9 @@ func (s *PersistentMemoryTrackingHandler) Clear() {
 	log.Println("Clearing tracking data??")
 	//s.changes++
 	//s.Sessions = make(map[int64]*SessionData)
-	//s.ItemEvents = map[uint][]DecayEvent{}
-	//s.FieldEvents = map[uint][]DecayEvent{}
-	//s.EmptyResults = make([]SearchEvent, 0)
+	//s.ItemEvents = DecayList{}
+	//s.FieldEvents = DecayList{}
+	//s.NoResults = make(map[string]*NoResultQuery)
 }
 
 func (s *PersistentMemoryTrackingHandler) GetSession(sessionId int64) *SessionData {
@@ -401,19 +652,40 @@ func (s *PersistentMemoryTrackingHandler) GetSession(sessionId int64) *SessionDa
 	if ok {
 		return session
 	}
+	if sessionStorage, ok := s.storage.(SessionStorage); ok {
+		session, err := sessionStorage.LoadSession(sessionId)
+		if err != nil {
+			log.Printf("Failed to load session %d: %v", sessionId, err)
+		}
+		return session
+	}
 	return nil
 }
 
+// findSession returns the session from memory or loads it from the storage,
+// must be called with the write lock held
+func (s *PersistentMemoryTrackingHandler) findSession(sessionId int64) (*SessionData, bool) {
+	session, ok := s.Sessions[sessionId]
+	if ok {
+		return session, true
+	}
+	if sessionStorage, ok := s.storage.(SessionStorage); ok {
+		session, err := sessionStorage.LoadSession(sessionId)
+		if err != nil {
+			log.Printf("Failed to load session %d: %v", sessionId, err)
+		}
+		if session != nil {
+			s.Sessions[sessionId] = session
+			return session, true
+		}
+	}
+	return nil, false
+}
+
 func (s *PersistentMemoryTrackingHandler) writeFile(path string) error {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	file, err := os.Create(path)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-	err = json.NewEncoder(file).Encode(s)
-	return err
+	return writeSnapshot(path, s)
 }
 
 func (s *PersistentMemoryTrackingHandler) GetFunnels() ([]Funnel, error) {
@@ -442,24 +714,16 @@ func (s *PersistentMemoryTrackingHandler) GetItemPopularity() map[uint]float64 {
 	return s.ItemPopularity
 }
 
-func (s *PersistentMemoryTrackingHandler) GetSuggestions(q string) interface{} {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	if q == "" {
-		return s.SortedQueries
-	}
-	lq := strings.TrimSpace(strings.ToLower(q))
-
-	matching := make([]QueryResult, 0)
-	for _, query := range s.SortedQueries {
-		if strings.Contains(query.Query, lq) {
-			matching = append(matching, query)
-		}
-	}
-	if len(matching) > 0 {
-		return matching
+// GetSuggestions returns the best queries starting with q, or with a word in
+// the query starting with q, from the index built by DecaySuggestions. When
+// too few queries match, queries within a typo or two of q are added as
+// corrected suggestions. q is normalized like the queries of the country
+func (s *PersistentMemoryTrackingHandler) GetSuggestions(q string, country string, limit int) []Suggestion {
+	index := s.suggestions.Load()
+	if index == nil {
+		return []Suggestion{}
 	}
-	return []QueryResult{}
+	return index.Lookup(NormalizeQuery(q, country), limit)
 }
 
 func (s *PersistentMemoryTrackingHandler) GetQueries() map[string]uint {
@@ -468,12 +732,6 @@ func (s *PersistentMemoryTrackingHandler) GetQueries() map[string]uint {
 	return s.Queries
 }
 
-func (s *PersistentMemoryTrackingHandler) GetNoResultQueries() []SearchEvent {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	return s.EmptyResults
-}
-
 type SessionOverview struct {
 	*SessionContent
 	Id         string `json:"id"`
@@ -536,7 +794,7 @@ func (s *PersistentMemoryTrackingHandler) HandleSessionEvent(event Session) {
 	opsProcessed.Inc()
 
 	//events := make([]interface{}, 0)
-	s.updateSession(event, event.SessionId, nil)
+	s.updateSession(event, event.SessionId, nil, s.eventTime(event.BaseEvent))
 	// s.Sessions[event.SessionId] = &SessionData{
 	// 	SessionContent: &event.SessionContent,
 	// 	Created:        time.Now().Unix(),
@@ -544,8 +802,8 @@ func (s *PersistentMemoryTrackingHandler) HandleSessionEvent(event Session) {
 	// 	Events:         events,
 	// 	VisitedSkus:    make([]string, 0),
 	// 	Id:             event.SessionId,
-	// 	ItemEvents:     make(map[uint][]DecayEvent),
-	// 	FieldEvents:    make(map[uint][]DecayEvent),
+	// 	ItemEvents:     NewDecayList(ProfileItem),
+	// 	FieldEvents:    NewDecayList(ProfileField),
 	// }
 }
 
@@ -553,13 +811,23 @@ func (s *PersistentMemoryTrackingHandler) HandleEvent(event Event, r *http.Reque
 	// log.Printf("Event SessionId: %d, ItemId: %d, Position: %f", event.SessionId, event.Item, event.Position)
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.ItemEvents.Add(event.Id, DecayEvent{
-		TimeStamp: time.Now().Unix(),
-		Value:     200.0 + (0.1 * float64(min(event.Position, 300))),
+	now := s.eventTime(event.BaseEvent)
+	value := GetEventWeights().Global.Click.Value(event.Quantity, event.Position)
+	s.addItemEvent(event.Id, DecayEvent{
+		TimeStamp: now,
+		Value:     value,
 	})
+	s.ClickModel.AddClick(event.Id, event.Position, now)
+	s.ItemStats.Clicks.Add(event.Id, DecayEvent{TimeStamp: now, Value: 1})
 
 	go s.handleFunnels(&event)
-	s.updateSession(event, event.SessionId, r)
+	session := s.updateSession(event, event.SessionId, r, now)
+	leaveSearch(session, event.GetContext())
+	s.attributeToQuery(session, event.Id, value, now)
+	if judgement := s.sessionJudgement(session, event.Id, now); judgement != nil {
+		judgement.Clicks++
+	}
+	s.creditReformulation(session, false, now)
 
 	s.changes++
 	go opsProcessed.Inc()
@@ -591,10 +859,10 @@ func (s *PersistentMemoryTrackingHandler) handleLinkedProducts(session *SessionD
 						ItemId: viewed,
 						Other:  make(map[uint]DecayList),
 					}
-					list := make(DecayList, 0)
+					list := NewDecayList(ProfileRelation)
 					list.Add(e.Id, DecayEvent{
 						TimeStamp: time.Now().Unix(),
-						Value:     20,
+						Value:     GetEventWeights().Global.ViewedTogether.Value(0, 0),
 					})
 
 					viewedRelation.Other[e.Id] = list
@@ -610,30 +878,129 @@ func (s *PersistentMemoryTrackingHandler) HandleEnterCheckout(event EnterCheckou
 	// log.Printf("EnterCheckout event SessionId: %d, ItemId: %d, Quantity: %d", event.SessionId, event.Item, event.Quantity)
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	weight := GetEventWeights().Global.Checkout
+	now := s.eventTime(event.BaseEvent)
+	for _, item := range event.Items {
+		s.addItemEvent(item.Id, DecayEvent{
+			TimeStamp: now,
+			Value:     weight.Value(item.Quantity, item.Position),
+		})
+		s.ItemStats.Checkouts.Add(item.Id, DecayEvent{TimeStamp: now, Value: 1})
+	}
+	s.changes++
+	go opsProcessed.Inc()
+	go s.handleFunnels(&event)
+	s.updateSession(event, event.SessionId, r, now)
+}
+
+func (s *PersistentMemoryTrackingHandler) HandlePurchaseEvent(event PurchaseEvent, r *http.Request) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, found := s.Orders[event.OrderId]; found {
+		return ErrDuplicateOrder
+	}
+	now := s.eventTime(event.BaseEvent)
+	s.Orders[event.OrderId] = OrderSummary{
+		SessionId: event.SessionId,
+		TimeStamp: now,
+		Revenue:   event.Revenue,
+		Currency:  event.Currency,
+		Items:     len(event.Items),
+	}
+	weight := GetEventWeights().Global.Purchase
 	for _, item := range event.Items {
-		s.ItemEvents.Add(item.Id, DecayEvent{
-			TimeStamp: time.Now().Unix(),
-			Value:     200.0 * float64(item.Quantity),
+		s.addItemEvent(item.Id, DecayEvent{
+			TimeStamp: now,
+			Value:     weight.Value(item.Quantity, item.Position),
 		})
 	}
+	s.addBoughtTogether(event.Items, now)
 	s.changes++
 	go opsProcessed.Inc()
 	go s.handleFunnels(&event)
-	s.updateSession(event, event.SessionId, r)
+	session := s.updateSession(event, event.SessionId, r, now)
+	for _, item := range event.Items {
+		s.attributeToQuery(session, item.Id, weight.Value(item.Quantity, item.Position), now)
+		if judgement := s.sessionJudgement(session, item.Id, now); judgement != nil {
+			judgement.Purchases++
+		}
+	}
+	return nil
+}
+
+func (s *PersistentMemoryTrackingHandler) addBoughtTogether(items []BaseItem, now int64) {
+	weight := GetEventWeights().Global.BoughtTogether.Value(0, 0)
+	for _, item := range items {
+		for _, other := range items {
+			if other.Id == item.Id {
+				continue
+			}
+			relation, ok := s.AlsoBought[item.Id]
+			if !ok {
+				relation = ProductRelation{
+					ItemId: item.Id,
+					Other:  make(map[uint]DecayList),
+				}
+				s.AlsoBought[item.Id] = relation
+			}
+			list, ok := relation.Other[other.Id]
+			if !ok {
+				list = NewDecayList(ProfileRelation)
+				relation.Other[other.Id] = list
+			}
+			list.Add(other.Id, DecayEvent{
+				TimeStamp: now,
+				Value:     weight,
+			})
+		}
+	}
+}
+
+func (s *PersistentMemoryTrackingHandler) GetAlsoBought(id uint) sorting.SortOverride {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := sorting.SortOverride{}
+	relation, ok := s.AlsoBought[id]
+	if !ok {
+		return result
+	}
+	now := time.Now().Unix()
+	for _, list := range relation.Other {
+		maps.Copy(result, list.Decay(now))
+	}
+	return result
 }
 
 func (s *PersistentMemoryTrackingHandler) HandleCartEvent(event CartEvent, r *http.Request) {
 	// log.Printf("Cart event SessionId: %d, ItemId: %d, Quantity: %d", event.SessionId, event.Item, event.Quantity)
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.ItemEvents.Add(event.Id, DecayEvent{
-		TimeStamp: time.Now().Unix(),
-		Value:     190.0 * float64(event.Quantity),
-	})
+	now := s.eventTime(event.BaseEvent)
+	added := event.Event == CART_ADD || event.Event == CART_LEGACY_ADD || event.Type == "add"
+	value := 0.0
+	if event.BaseItem != nil && event.Id > 0 {
+		value = GetEventWeights().Global.Cart.Value(event.Quantity, event.Position)
+		s.addItemEvent(event.Id, DecayEvent{
+			TimeStamp: now,
+			Value:     value,
+		})
+		if added {
+			s.ItemStats.Carts.Add(event.Id, DecayEvent{TimeStamp: now, Value: 1})
+		}
+	}
 	s.changes++
 	go opsProcessed.Inc()
 	go s.handleFunnels(&event)
-	s.updateSession(event, event.SessionId, r)
+	session := s.updateSession(event, event.SessionId, r, now)
+	if added {
+		if event.BaseItem != nil {
+			s.attributeToQuery(session, event.Id, value, now)
+			if judgement := s.sessionJudgement(session, event.Id, now); judgement != nil {
+				judgement.Carts++
+			}
+		}
+		s.creditReformulation(session, true, now)
+	}
 }
 
 func (s *PersistentMemoryTrackingHandler) HandleDataSetEvent(event DataSetEvent, r *http.Request) {
@@ -644,18 +1011,13 @@ func (s *PersistentMemoryTrackingHandler) HandleDataSetEvent(event DataSetEvent,
 	go opsProcessed.Inc()
 
 	s.DataSet = append(s.DataSet, event)
-}
-
-func normalizeQuery(query string) string {
-	query = strings.ToLower(query)
-	query = strings.TrimSpace(query)
-	return query
+	s.addDataSetJudgement(event, s.eventTime(event.BaseEvent))
 }
 
 func (s *PersistentMemoryTrackingHandler) UpdateSessionFromRequest(sessionId int64, r *http.Request) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	session, ok := s.Sessions[sessionId]
+	session, ok := s.findSession(sessionId)
 	if ok {
 		session.Ip = r.RemoteAddr
 		s.Sessions[sessionId] = session
@@ -665,45 +1027,68 @@ func (s *PersistentMemoryTrackingHandler) UpdateSessionFromRequest(sessionId int
 
 func (s *PersistentMemoryTrackingHandler) HandleSearchEvent(event SearchEvent, r *http.Request) {
 	if event.NumberOfResults == 0 {
-		if s.EmptyResults == nil {
-			s.EmptyResults = make([]SearchEvent, 0)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.changes++
+		ts := s.eventTime(event.BaseEvent)
+		normalizedQuery := NormalizeQuery(event.Query, event.GetCountry())
+		if kind := classifyQuery(event.Query, normalizedQuery); kind != "" {
+			s.addJunkQuery(event.Query, kind, 1, ts)
+			return
 		}
-		if event.Query != "" {
-			s.EmptyResults = append(s.EmptyResults, event)
-			log.Printf("Search event with no results %+v", event)
+		if normalizedQuery != "" {
+			s.rememberQueryCountry(normalizedQuery, event.GetCountry())
+			addNoResult(s.NoResults, normalizedQuery, event, ts)
+			log.Printf("Search event with no results %s", normalizedQuery)
+			if event.BaseEvent != nil {
+				// a query without results is the most likely to be reformulated
+				if session, ok := s.findSession(event.SessionId); ok {
+					s.trackSearchReformulation(session, normalizedQuery, event.GetContext(), ts)
+				}
+			}
 		}
-
 		return
 	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	s.changes++
 	go opsProcessed.Inc()
-	ts := time.Now().Unix()
+	ts := s.eventTime(event.BaseEvent)
+	weights := GetEventWeights().Global
+
+	normalizedQuery := NormalizeQuery(event.Query, event.GetCountry())
+	// junk is counted on its own, searches for * still track their filters
+	kind := classifyQuery(event.Query, normalizedQuery)
+	if kind != "" {
+		s.addJunkQuery(event.Query, kind, 1, ts)
+	}
 
 	if event.Query != "" && event.Query != "*" {
-		normalizedQuery := normalizeQuery(event.Query)
-		s.Queries[normalizedQuery] += 1
-
-		if normalizedQuery != "" {
+		if normalizedQuery != "" && kind == "" {
+			s.rememberQueryCountry(normalizedQuery, event.GetCountry())
+			s.Queries[normalizedQuery] += 1
 			queryEvents, ok := s.QueryEvents[normalizedQuery]
 			if !ok {
 				queryEvents = QueryMatcher{
 					//Query:      event.Query,
-					Popularity: &DecayPopularity{},
+					Profile:    ProfileQuery,
+					Popularity: NewDecayPopularity(ProfileQuery),
 					KeyFields:  make(map[uint]QueryKeyData),
 				}
 				s.QueryEvents[normalizedQuery] = queryEvents
 			}
-			queryEvents.Popularity.Add(DecayEvent{
+			queryEvent := DecayEvent{
 				TimeStamp: ts,
-				Value:     20.0, // + (float64(event.NumberOfResults) * 0.5),
-			})
+				Value:     weights.Query.Value(0, 0), // + (float64(event.NumberOfResults) * 0.5),
+			}
+			queryEvents.Popularity.Add(queryEvent)
+			s.addQueryEvent(normalizedQuery, queryEvent)
+			s.addJudgementSearch(normalizedQuery, ts)
 			//queryEvents.Popularity.Decay(ts)
 			for _, filter := range event.Filters.StringFilter {
 
 				for _, value := range filter.Value {
-					queryEvents.AddKeyFilterEvent(filter.Id, value)
+					queryEvents.AddKeyFilterEvent(filter.Id, value, ts)
 				}
 
 			}
@@ -711,9 +1096,9 @@ func (s *PersistentMemoryTrackingHandler) HandleSearchEvent(event SearchEvent, r
 	} else {
 
 		for _, filter := range event.Filters.StringFilter {
-			s.FieldEvents.Add(filter.Id, DecayEvent{
+			s.addFieldEvent(filter.Id, DecayEvent{
 				TimeStamp: ts,
-				Value:     40.0,
+				Value:     weights.Filter.Value(0, 0),
 			})
 			for _, filter := range event.Filters.StringFilter {
 				fieldValues, ok := s.FieldValueEvents[filter.Id]
@@ -724,12 +1109,12 @@ func (s *PersistentMemoryTrackingHandler) HandleSearchEvent(event SearchEvent, r
 				addFieldValueEvent := func(value string) {
 					fieldPopularity, ok := fieldValues[value]
 					if !ok {
-						fieldPopularity = &DecayPopularity{}
+						fieldPopularity = NewDecayPopularity(ProfileFacet)
 						fieldValues[value] = fieldPopularity
 					}
 					fieldPopularity.Add(DecayEvent{
 						TimeStamp: ts,
-						Value:     80,
+						Value:     weights.FilterValue.Value(0, 0),
 					})
 				}
 
@@ -740,22 +1125,23 @@ func (s *PersistentMemoryTrackingHandler) HandleSearchEvent(event SearchEvent, r
 			}
 		}
 		for _, filter := range event.Filters.RangeFilter {
-			s.FieldEvents.Add(filter.Id, DecayEvent{
+			s.addFieldEvent(filter.Id, DecayEvent{
 				TimeStamp: ts,
-				Value:     30,
+				Value:     weights.RangeFilter.Value(0, 0),
 			})
 		}
 	}
 
 	go s.handleFunnels(&event)
-	s.updateSession(event, event.SessionId, r)
-
+	session := s.updateSession(event, event.SessionId, r, ts)
+	if kind == "" {
+		s.trackSearchReformulation(session, normalizedQuery, event.GetContext(), ts)
+	}
 }
 
-func (s *PersistentMemoryTrackingHandler) updateSession(event interface{}, sessionId int64, r *http.Request) *SessionData {
+func (s *PersistentMemoryTrackingHandler) updateSession(event interface{}, sessionId int64, r *http.Request, now int64) *SessionData {
 
-	session, ok := s.Sessions[sessionId]
-	now := time.Now().Unix()
+	session, ok := s.findSession(sessionId)
 	log.Printf("handling session event %T, session found %v, id: %d", event, ok, sessionId)
 	if !ok {
 		sessions_total.Inc()
@@ -767,8 +1153,8 @@ func (s *PersistentMemoryTrackingHandler) updateSession(event interface{}, sessi
 			Id:             sessionId,
 			VisitedSkus:    make([]uint, 0),
 			Events:         make([]interface{}, 0),
-			ItemEvents:     make(map[uint][]DecayEvent),
-			FieldEvents:    make(map[uint][]DecayEvent),
+			ItemEvents:     NewDecayList(ProfileItem),
+			FieldEvents:    NewDecayList(ProfileField),
 		}
 		s.Sessions[sessionId] = session
 	} else {
@@ -778,11 +1164,11 @@ func (s *PersistentMemoryTrackingHandler) updateSession(event interface{}, sessi
 		}
 	}
 
-	user_groups := session.HandleEvent(event)
+	user_groups := session.HandleEvent(event, now)
 	for group, value := range user_groups {
 		if group != "" && value > 0 {
 			if mainGroup, ok := s.PersonalizationGroups[group]; ok {
-				mainGroup.HandleEvent(event)
+				mainGroup.HandleEvent(event, now)
 			}
 		}
 	}
@@ -793,14 +1179,25 @@ func (s *PersistentMemoryTrackingHandler) HandleImpressionEvent(event Impression
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	go opsProcessed.Inc()
+	weight := GetEventWeights().Global.Impression
+	now := s.eventTime(event.BaseEvent)
 	for _, impression := range event.Items {
-		s.ItemEvents.Add(impression.Id, DecayEvent{
-			TimeStamp: time.Now().Unix(),
-			Value:     float64(impression.Position),
+		s.addItemEvent(impression.Id, DecayEvent{
+			TimeStamp: now,
+			Value:     weight.Value(impression.Quantity, impression.Position),
 		})
+		s.ClickModel.AddImpression(impression.Id, impression.Position, now)
+		s.ItemStats.Impressions.Add(impression.Id, DecayEvent{TimeStamp: now, Value: 1})
 		//s.ItemPopularity[impression.Id] += 5.01 + float64(impression.Position)/10
 	}
-	s.updateSession(event, event.SessionId, r)
+	session := s.updateSession(event, event.SessionId, r, now)
+	leaveSearch(session, event.GetContext())
+	for _, impression := range event.Items {
+		if judgement := s.sessionJudgement(session, impression.Id, now); judgement != nil {
+			judgement.Impressions++
+			judgement.PositionSum += float64(impression.Position)
+		}
+	}
 
 	go s.handleFunnels(&event)
 	s.changes++
@@ -811,13 +1208,14 @@ func (s *PersistentMemoryTrackingHandler) HandleActionEvent(event ActionEvent, r
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	go opsProcessed.Inc()
+	now := s.eventTime(event.BaseEvent)
 	if event.BaseItem != nil && event.Id > 0 {
-		s.ItemEvents.Add(event.Id, DecayEvent{
-			TimeStamp: time.Now().Unix(),
-			Value:     30,
+		s.addItemEvent(event.Id, DecayEvent{
+			TimeStamp: now,
+			Value:     GetEventWeights().Global.Action.Value(event.Quantity, event.Position),
 		})
 	}
-	s.updateSession(event, event.SessionId, r)
+	s.updateSession(event, event.SessionId, r, now)
 	go s.handleFunnels(&event)
 	s.changes++
 }
@@ -826,9 +1224,16 @@ func (s *PersistentMemoryTrackingHandler) HandleSuggestEvent(event SuggestEvent,
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	go opsProcessed.Inc()
-	s.updateSession(event, event.SessionId, r)
+	now := s.eventTime(event.BaseEvent)
+	s.updateSession(event, event.SessionId, r, now)
 	go s.handleFunnels(&event)
-	s.Queries[event.Value] += 1
+	query := NormalizeQuery(event.Value, event.GetCountry())
+	if kind := classifyQuery(event.Value, query); kind != "" {
+		s.addJunkQuery(event.Value, kind, 1, now)
+	} else if query != "" {
+		s.rememberQueryCountry(query, event.GetCountry())
+		s.Queries[query] += 1
+	}
 	// TODO update this to somethign useful
 	// TODO add decay to this
 	//log.Printf("Suggest %s", event.Value)
@@ -74,3 +74,30 @@ func TestDecayEvent_CalculateValue(t *testing.T) {
 		}
 	})
 }
+
+func TestHandlePurchaseEvent(t *testing.T) {
+	handler := MakeMemoryTrackingHandler(t.TempDir()+"/tracking.json", 500)
+	purchase := PurchaseEvent{
+		BaseEvent: &BaseEvent{Event: CART_PURCHASE, SessionId: 1},
+		OrderId:   "order-1",
+		Revenue:   199,
+		Currency:  "NOK",
+		Items: []BaseItem{
+			{Id: 1, Quantity: 1},
+			{Id: 2, Quantity: 2},
+		},
+	}
+	if err := handler.HandlePurchaseEvent(purchase, nil); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := handler.HandlePurchaseEvent(purchase, nil); err != ErrDuplicateOrder {
+		t.Errorf("Expected duplicate order error, got %v", err)
+	}
+	if value := handler.ItemEvents.Values[2]; value.Sum != 1600 {
+		t.Errorf("Expected a single purchase for item 2, got %v", value.Sum)
+	}
+	alsoBought := handler.GetAlsoBought(1)
+	if _, ok := alsoBought[2]; !ok {
+		t.Errorf("Expected item 2 to be bought together with item 1")
+	}
+}
@@ -0,0 +1,140 @@
+package view
+
+import (
+	"cmp"
+	"log"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/matst80/slask-finder/pkg/sorting"
+)
+
+const (
+	trendingShortWindow = "1h"
+	trendingBaseWindow  = "7d"
+	// the short window needs the weight of this many events before anything
+	// trends, it is also used as prior so a handful of events do not spike the
+	// ratio. Items and queries are weighted differently so each gets its own
+	// minimum from the weight of a click and a search
+	trendingMinEvents = 2
+	trendingMinRatio  = 3
+	trendingLimit     = 100
+)
+
+type TrendingItem struct {
+	Id       uint    `json:"id"`
+	Rate     float64 `json:"rate"`
+	Baseline float64 `json:"baseline"`
+	Ratio    float64 `json:"ratio"`
+}
+
+type TrendingQuery struct {
+	Query    string  `json:"query"`
+	Rate     float64 `json:"rate"`
+	Baseline float64 `json:"baseline"`
+	Ratio    float64 `json:"ratio"`
+}
+
+type Trending struct {
+	Items   []TrendingItem  `json:"items"`
+	Queries []TrendingQuery `json:"queries"`
+}
+
+// trendingRates compares the rate in the short window against the baseline
+// from the long window, a decayed sum divided by the mean lifetime of the
+// profile is the rate per hour at a steady flow of events
+type trendingRates struct {
+	short    float64
+	baseline float64
+	min      float64
+	prior    float64
+}
+
+func meanLifetimeHours(profile *DecayProfile) float64 {
+	return float64(profile.HalfLife) / math.Ln2 / 3600
+}
+
+func newTrendingRates(weight Weight) trendingRates {
+	short := meanLifetimeHours(GetDecayProfile(windowProfileName(trendingShortWindow)))
+	minValue := trendingMinEvents * max(weight.Value(0, 0), 1)
+	return trendingRates{
+		short:    short,
+		baseline: meanLifetimeHours(GetDecayProfile(windowProfileName(trendingBaseWindow))),
+		min:      minValue,
+		prior:    minValue / short,
+	}
+}
+
+func (t trendingRates) compare(short float64, baseline float64) (rate float64, base float64, ratio float64, ok bool) {
+	rate = short / t.short
+	base = baseline / t.baseline
+	ratio = (rate + t.prior) / (base + t.prior)
+	return rate, base, ratio, short >= t.min && ratio >= trendingMinRatio
+}
+
+func (s *PersistentMemoryTrackingHandler) getTrending(now int64) Trending {
+	result := Trending{
+		Items:   make([]TrendingItem, 0),
+		Queries: make([]TrendingQuery, 0),
+	}
+	short, ok := s.Windows[trendingShortWindow]
+	if !ok {
+		return result
+	}
+	base, ok := s.Windows[trendingBaseWindow]
+	if !ok {
+		return result
+	}
+	weights := GetEventWeights().Global
+
+	itemRates := newTrendingRates(weights.Click)
+	baseItems := base.Items.Decay(now)
+	for id, value := range short.Items.Decay(now) {
+		if rate, baseline, ratio, ok := itemRates.compare(value, baseItems[id]); ok {
+			result.Items = append(result.Items, TrendingItem{Id: id, Rate: rate, Baseline: baseline, Ratio: ratio})
+		}
+	}
+	queryRates := newTrendingRates(weights.Query)
+	baseQueries := base.queryPopularity(now)
+	for query, value := range short.queryPopularity(now) {
+		if rate, baseline, ratio, ok := queryRates.compare(value, baseQueries[query]); ok {
+			result.Queries = append(result.Queries, TrendingQuery{Query: query, Rate: rate, Baseline: baseline, Ratio: ratio})
+		}
+	}
+	slices.SortFunc(result.Items, func(a, b TrendingItem) int {
+		return cmp.Compare(b.Ratio, a.Ratio)
+	})
+	slices.SortFunc(result.Queries, func(a, b TrendingQuery) int {
+		return cmp.Compare(b.Ratio, a.Ratio)
+	})
+	if len(result.Items) > trendingLimit {
+		result.Items = result.Items[:trendingLimit]
+	}
+	if len(result.Queries) > trendingLimit {
+		result.Queries = result.Queries[:trendingLimit]
+	}
+	return result
+}
+
+func (s *PersistentMemoryTrackingHandler) GetTrending() Trending {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.getTrending(time.Now().Unix())
+}
+
+// PublishTrending sends the trending items as the trending sort override,
+// scored by how many times above their baseline they are
+func (s *PersistentMemoryTrackingHandler) PublishTrending() {
+	if s.trackingHandler == nil {
+		return
+	}
+	trending := s.GetTrending()
+	sort := make(sorting.SortOverride, len(trending.Items))
+	for _, item := range trending.Items {
+		sort[item.Id] = item.Ratio
+	}
+	if err := s.trackingHandler.SortOverrideChanged("trending", &sort); err != nil {
+		log.Printf("Failed to publish trending items: %v", err)
+	}
+}
@@ -0,0 +1,38 @@
+package view
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTrendingComparesShortWindowWithBaseline(t *testing.T) {
+	handler := MakeMemoryTrackingHandler(filepath.Join(t.TempDir(), "tracking.json"), 500)
+	now := time.Now().Unix()
+	weights := GetEventWeights().Global
+	click := weights.Click.Value(0, 0)
+	search := weights.Query.Value(0, 0)
+	// item 1 has a steady flow of clicks over the last week, item 2 only spiked
+	// during the last hour
+	for ts := now - 3600*24*7; ts <= now; ts += 3600 {
+		handler.addItemEvent(1, DecayEvent{TimeStamp: ts, Value: click})
+	}
+	for i := range 10 {
+		ts := now - int64(i*60)
+		handler.addItemEvent(2, DecayEvent{TimeStamp: ts, Value: click})
+		handler.addQueryEvent("airfryer", DecayEvent{TimeStamp: ts, Value: search})
+	}
+	// a single search is not a trend
+	handler.addQueryEvent("kettle", DecayEvent{TimeStamp: now, Value: search})
+
+	trending := handler.GetTrending()
+	if len(trending.Items) != 1 || trending.Items[0].Id != 2 {
+		t.Fatalf("Expected only item 2 to trend, got %+v", trending.Items)
+	}
+	if trending.Items[0].Ratio < trendingMinRatio {
+		t.Errorf("Expected ratio above %d, got %v", trendingMinRatio, trending.Items[0].Ratio)
+	}
+	if len(trending.Queries) != 1 || trending.Queries[0].Query != "airfryer" {
+		t.Errorf("Expected only airfryer to trend, got %+v", trending.Queries)
+	}
+}
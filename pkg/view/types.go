@@ -24,6 +24,13 @@ const (
 	CART_CLEAR          = uint16(13)
 	CART_ENTER_CHECKOUT = uint16(14)
 	CART_QUANTITY       = uint16(15)
+	CART_PURCHASE       = uint16(16)
+)
+
+const (
+	// cart codes used by older producers before the CART_* constants existed
+	CART_LEGACY_ADD    = uint16(3)
+	CART_LEGACY_REMOVE = uint16(4)
 )
 
 type BaseEvent struct {
@@ -41,6 +48,22 @@ type DataSetEvent struct {
 	Negative string `json:"negative,omitempty"`
 }
 
+// GetCountry is safe to call on events without a base event
+func (e *BaseEvent) GetCountry() string {
+	if e == nil {
+		return ""
+	}
+	return e.Country
+}
+
+// GetContext is safe to call on events without a base event
+func (e *BaseEvent) GetContext() string {
+	if e == nil {
+		return ""
+	}
+	return e.Context
+}
+
 func (e *BaseEvent) SetTimestamp() {
 	if e.TimeStamp == 0 {
 		e.TimeStamp = time.Now().Unix()
@@ -113,7 +136,10 @@ type Purchase struct {
 
 type PurchaseEvent struct {
 	*BaseEvent
-	Items []BaseItem `json:"items"`
+	OrderId  string     `json:"order_id"`
+	Revenue  float64    `json:"revenue,omitempty"`
+	Currency string     `json:"currency,omitempty"`
+	Items    []BaseItem `json:"items"`
 	//Referer string     `json:"referer,omitempty"`
 }
 
@@ -133,6 +159,8 @@ type PopularityListener interface {
 	SessionFieldPopularityChanged(sessionId int64, sort *sorting.SortOverride) error
 	GroupPopularityChanged(groupId string, sort *sorting.SortOverride) error
 	GroupFieldPopularityChanged(groupId string, sort *sorting.SortOverride) error
+	SortOverrideChanged(key string, sort *sorting.SortOverride) error
+	FieldSortOverrideChanged(key string, sort *sorting.SortOverride) error
 }
 
 type Impression struct {
@@ -198,6 +226,10 @@ func (e *EnterCheckoutEvent) GetType() uint16 {
 	return e.Event
 }
 
+func (e *DataSetEvent) GetType() uint16 {
+	return e.Event
+}
+
 func (e *Event) GetBaseEvent() *BaseEvent {
 	return e.BaseEvent
 }
@@ -234,6 +266,10 @@ func (e *EnterCheckoutEvent) GetBaseEvent() *BaseEvent {
 	return e.BaseEvent
 }
 
+func (e *DataSetEvent) GetBaseEvent() *BaseEvent {
+	return e.BaseEvent
+}
+
 func (e *Event) GetTags() []string {
 	return []string{}
 }
@@ -270,6 +306,10 @@ func (e *EnterCheckoutEvent) GetTags() []string {
 	return []string{}
 }
 
+func (e *DataSetEvent) GetTags() []string {
+	return []string{e.Query}
+}
+
 type TrackingEvent interface {
 	GetType() uint16
 	GetBaseEvent() *BaseEvent
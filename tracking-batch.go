@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/matst80/slask-tracking/pkg/events"
+	"github.com/matst80/slask-tracking/pkg/view"
+)
+
+const (
+	maxBatchSize  = 500
+	maxBatchBytes = 1 << 20
+)
+
+type BatchEntryResult struct {
+	Index  int    `json:"index"`
+	Event  uint16 `json:"event"`
+	Reason string `json:"reason,omitempty"`
+}
+
+type BatchResult struct {
+	Accepted []BatchEntryResult `json:"accepted"`
+	Rejected []BatchEntryResult `json:"rejected"`
+}
+
+// readBatch accepts either a json array of events or a stream of
+// newline delimited json objects
+func readBatch(body io.Reader) ([]json.RawMessage, error) {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimSpace(data)
+	entries := make([]json.RawMessage, 0)
+	if len(data) == 0 {
+		return entries, nil
+	}
+	if data[0] == '[' {
+		err = json.Unmarshal(data, &entries)
+		return entries, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	for {
+		var entry json.RawMessage
+		err = decoder.Decode(&entry)
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return entries, err
+		}
+		entries = append(entries, entry)
+	}
+}
+
+// batchEvents are the registry names a batch may contain, sessions, searches
+// and purchases only come through their own endpoints
+var batchEvents = map[string]bool{
+	"click":         true,
+	"impression":    true,
+	"cart":          true,
+	"cart_add":      true,
+	"cart_remove":   true,
+	"cart_quantity": true,
+	"cart_clear":    true,
+	"action":        true,
+	"suggest":       true,
+	"checkout":      true,
+	"dataset":       true,
+}
+
+// decodeBatchEntry validates a single batch entry with the same decoders as
+// the tracking topic and binds it to the session of the request, the time is
+// set by the server like for the single event endpoints
+func decodeBatchEntry(raw json.RawMessage, sessionId int64, r *http.Request) (uint16, view.TrackingEvent, error) {
+	eventType, event, err := events.DecodeEvent(raw)
+	if registration, ok := events.Registry[eventType]; ok && !batchEvents[registration.Name] {
+		return eventType, nil, fmt.Errorf("%s events can not be sent in a batch", registration.Name)
+	}
+	if err != nil {
+		return eventType, nil, err
+	}
+	base := event.GetBaseEvent()
+	base.SessionId = sessionId
+	base.TimeStamp = time.Now().Unix()
+	if action, ok := event.(*view.ActionEvent); ok {
+		action.Referer = r.Header.Get("Referer")
+	}
+	return eventType, event, nil
+}
+
+func TrackBatch(trk view.TrackingHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "OPTIONS" {
+			setPreflightHeaders(w, r)
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		w.Header().Set("Cache-Control", "private, stale-while-revalidate=5")
+		if trk == nil {
+			http.Error(w, "Tracking not enabled", http.StatusNotImplemented)
+			return
+		}
+		sessionId := HandleSessionCookie(trk, w, r)
+		entries, err := readBatch(http.MaxBytesReader(w, r.Body, maxBatchBytes))
+		if err != nil {
+			var maxBytesError *http.MaxBytesError
+			if errors.As(err, &maxBytesError) {
+				http.Error(w, fmt.Sprintf("batch too large, max %d bytes", maxBatchBytes), http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(entries) > maxBatchSize {
+			http.Error(w, fmt.Sprintf("batch too large, max %d events", maxBatchSize), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		result := BatchResult{
+			Accepted: make([]BatchEntryResult, 0, len(entries)),
+			Rejected: make([]BatchEntryResult, 0),
+		}
+		// the events are handled before responding so the result tells which
+		// events were applied
+		for i, raw := range entries {
+			eventType, event, err := decodeBatchEntry(raw, sessionId, r)
+			if err == nil {
+				err = events.HandleEvent(trk, event, r)
+			}
+			if err != nil {
+				result.Rejected = append(result.Rejected, BatchEntryResult{
+					Index:  i,
+					Event:  eventType,
+					Reason: err.Error(),
+				})
+				continue
+			}
+			result.Accepted = append(result.Accepted, BatchEntryResult{
+				Index: i,
+				Event: eventType,
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		origin := r.Header.Get("Origin")
+		if origin != "" {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+		w.WriteHeader(http.StatusAccepted)
+		if err := json.NewEncoder(w).Encode(result); err != nil {
+			log.Printf("error responding: %v", err)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/matst80/slask-tracking/pkg/view"
+)
+
+// recordingHandler keeps the events handed to it, wait blocks until the
+// expected number has arrived since the handlers run in the background
+type recordingHandler struct {
+	mu     sync.Mutex
+	events []view.TrackingEvent
+}
+
+func (h *recordingHandler) add(event view.TrackingEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.events = append(h.events, event)
+}
+
+func (h *recordingHandler) wait(t *testing.T, count int) []view.TrackingEvent {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		h.mu.Lock()
+		if len(h.events) >= count {
+			events := h.events
+			h.mu.Unlock()
+			return events
+		}
+		h.mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Expected %d events, got %d", count, len(h.events))
+	return nil
+}
+
+func (h *recordingHandler) HandleSessionEvent(event view.Session) { h.add(&event) }
+func (h *recordingHandler) HandleEvent(event view.Event, r *http.Request) {
+	h.add(&event)
+}
+func (h *recordingHandler) HandleSearchEvent(event view.SearchEvent, r *http.Request) {
+	h.add(&event)
+}
+func (h *recordingHandler) HandleCartEvent(event view.CartEvent, r *http.Request) {
+	h.add(&event)
+}
+func (h *recordingHandler) HandleDataSetEvent(event view.DataSetEvent, r *http.Request) {
+	h.add(&event)
+}
+func (h *recordingHandler) HandleEnterCheckout(event view.EnterCheckoutEvent, r *http.Request) {
+	h.add(&event)
+}
+func (h *recordingHandler) HandlePurchaseEvent(event view.PurchaseEvent, r *http.Request) error {
+	h.add(&event)
+	return nil
+}
+func (h *recordingHandler) HandleImpressionEvent(event view.ImpressionEvent, r *http.Request) {
+	h.add(&event)
+}
+func (h *recordingHandler) HandleActionEvent(event view.ActionEvent, r *http.Request) {
+	h.add(&event)
+}
+func (h *recordingHandler) HandleSuggestEvent(event view.SuggestEvent, r *http.Request) {
+	h.add(&event)
+}
+func (h *recordingHandler) GetSession(sessionId int64) *view.SessionData { return nil }
+
+func postBatch(t *testing.T, handler view.TrackingHandler, body string) (*httptest.ResponseRecorder, BatchResult) {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	TrackBatch(handler)(recorder, httptest.NewRequest(http.MethodPost, "/track/batch", strings.NewReader(body)))
+	var result BatchResult
+	if recorder.Code == http.StatusAccepted {
+		if err := json.Unmarshal(recorder.Body.Bytes(), &result); err != nil {
+			t.Fatalf("Failed to decode response %q: %v", recorder.Body.String(), err)
+		}
+	}
+	return recorder, result
+}
+
+func TestTrackBatch(t *testing.T) {
+	entries := []string{
+		`{"event":2,"id":10,"ts":1}`,
+		`{"event":2}`,
+		`{"event":7,"value":"tv"}`,
+		`{"event":99}`,
+		`{"event":1,"query":"tv","noi":3}`,
+		`{"event":16,"order_id":"1","items":[{"id":1}]}`,
+		`{"event":0}`,
+	}
+	bodies := map[string]string{
+		"json array": "[" + strings.Join(entries, ",") + "]",
+		"ndjson":     strings.Join(entries, "\n") + "\n",
+	}
+	for name, body := range bodies {
+		t.Run(name, func(t *testing.T) {
+			handler := &recordingHandler{}
+			recorder, result := postBatch(t, handler, body)
+			if recorder.Code != http.StatusAccepted {
+				t.Fatalf("Expected 202, got %d %s", recorder.Code, recorder.Body.String())
+			}
+			if len(result.Accepted) != 2 || result.Accepted[0].Index != 0 || result.Accepted[1].Index != 2 {
+				t.Errorf("Unexpected accepted entries %+v", result.Accepted)
+			}
+			rejected := make([]int, 0)
+			for _, entry := range result.Rejected {
+				if entry.Reason == "" {
+					t.Errorf("Expected a reason for entry %d", entry.Index)
+				}
+				rejected = append(rejected, entry.Index)
+			}
+			if fmt.Sprint(rejected) != "[1 3 4 5 6]" || result.Rejected[1].Event != 99 || result.Rejected[2].Event != view.EVENT_SEARCH {
+				t.Errorf("Unexpected rejected entries %+v", result.Rejected)
+			}
+			// the events are handled before the response is written
+			if len(handler.events) != 2 || handler.events[0].GetType() != view.EVENT_ITEM_CLICK || handler.events[1].GetType() != view.EVENT_SUGGEST {
+				t.Errorf("Expected events in batch order, got %+v", handler.events)
+			}
+			if ts := handler.events[0].GetBaseEvent().TimeStamp; ts < time.Now().Add(-time.Minute).Unix() {
+				t.Errorf("Expected the server time instead of the client time, got %d", ts)
+			}
+		})
+	}
+}
+
+func TestTrackBatchLimits(t *testing.T) {
+	entries := make([]string, maxBatchSize+1)
+	for i := range entries {
+		entries[i] = `{"event":2,"id":1}`
+	}
+	recorder, _ := postBatch(t, &recordingHandler{}, "["+strings.Join(entries, ",")+"]")
+	if recorder.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413 for too many events, got %d", recorder.Code)
+	}
+
+	padding := strings.Repeat("x", maxBatchBytes)
+	recorder, _ = postBatch(t, &recordingHandler{}, fmt.Sprintf(`[{"event":5,"items":[{"id":1,"item_name":"%s"}]}]`, padding))
+	if recorder.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413 for a body over the size limit, got %d", recorder.Code)
+	}
+
+	recorder, _ = postBatch(t, &recordingHandler{}, `[{"event":2,"id":1}`)
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for malformed json, got %d", recorder.Code)
+	}
+}
@@ -2,28 +2,34 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/matst80/slask-finder/pkg/types"
 	"github.com/matst80/slask-tracking/pkg/view"
 )
 
+func setPreflightHeaders(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	origin := r.Header.Get("Origin")
+	if origin != "" && !strings.Contains(origin, "localhost") {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Max-Age", "86400")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "*")
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+	w.Header().Set("Age", "0")
+}
+
 func TrackHandler(trk view.TrackingHandler, handler func(r *http.Request, sessionId int64, trackingHandler view.TrackingHandler) error) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == "OPTIONS" {
-			w.Header().Set("Cache-Control", "public, max-age=3600")
-			origin := r.Header.Get("Origin")
-			if origin != "" && !strings.Contains(origin, "localhost") {
-				w.Header().Set("Access-Control-Allow-Origin", origin)
-				w.Header().Set("Access-Control-Max-Age", "86400")
-				w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-				w.Header().Set("Access-Control-Allow-Headers", "*")
-				w.Header().Set("Access-Control-Allow-Credentials", "true")
-			}
-			w.Header().Set("Age", "0")
+			setPreflightHeaders(w, r)
 		} else {
 			w.Header().Set("Cache-Control", "private, stale-while-revalidate=5")
 			if trk == nil {
@@ -102,12 +108,6 @@ type ActionData struct {
 	Reason string `json:"reason"`
 }
 
-type SuggestData struct {
-	Value       string `json:"value"`
-	Suggestions int    `json:"suggestions"`
-	Results     int    `json:"results"`
-}
-
 func TrackAction(r *http.Request, sessionId int64, trk view.TrackingHandler) error {
 
 	var data ActionData
@@ -129,19 +129,52 @@ func TrackAction(r *http.Request, sessionId int64, trk view.TrackingHandler) err
 
 func TrackSuggest(r *http.Request, sessionId int64, trk view.TrackingHandler) error {
 
-	var data SuggestData
+	var data view.SuggestEvent
 	err := json.NewDecoder(r.Body).Decode(&data)
 	if err != nil {
 		return err
 	}
 
-	go trk.HandleSuggestEvent(view.SuggestEvent{
-		BaseEvent:   &view.BaseEvent{Event: view.EVENT_SUGGEST, SessionId: sessionId, TimeStamp: time.Now().Unix()},
-		Value:       data.Value,
-		Suggestions: data.Suggestions,
-		Results:     data.Results,
-		//Referer:     referer,
-	}, r)
+	// the country and context come from the client, the time is always set
+	// by the server
+	if data.BaseEvent == nil {
+		data.BaseEvent = &view.BaseEvent{}
+	}
+	data.Event = view.EVENT_SUGGEST
+	data.SessionId = sessionId
+	data.TimeStamp = time.Now().Unix()
+
+	go trk.HandleSuggestEvent(data, r)
+
+	return nil
+}
+
+func TrackSearch(r *http.Request, sessionId int64, trk view.TrackingHandler) error {
+	var data view.SearchEvent
+	err := json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		return err
+	}
+	if data.NumberOfResults < 0 || data.Page < 0 {
+		return errors.New("invalid result count or page")
+	}
+	if data.Filters == nil {
+		data.Filters = &types.Filters{}
+	}
+	if data.Query == "" && len(data.StringFilter) == 0 && len(data.RangeFilter) == 0 {
+		return errors.New("search without query or filters")
+	}
+
+	// country, context and the client timestamp are kept, the session and
+	// event type always come from the server
+	if data.BaseEvent == nil {
+		data.BaseEvent = &view.BaseEvent{}
+	}
+	data.Event = view.EVENT_SEARCH
+	data.SessionId = sessionId
+	data.SetTimestamp()
+
+	go trk.HandleSearchEvent(data, r)
 
 	return nil
 }
@@ -186,6 +219,37 @@ func TrackCheckout(r *http.Request, sessionId int64, trk view.TrackingHandler) e
 	return nil
 }
 
+type PurchaseData struct {
+	OrderId  string          `json:"order_id"`
+	Revenue  float64         `json:"revenue"`
+	Currency string          `json:"currency"`
+	Items    []view.BaseItem `json:"items"`
+}
+
+func TrackPurchase(r *http.Request, sessionId int64, trk view.TrackingHandler) error {
+
+	var data PurchaseData
+	err := json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		return err
+	}
+	if data.OrderId == "" {
+		return errors.New("missing order id")
+	}
+	if len(data.Items) == 0 {
+		return errors.New("no items in purchase")
+	}
+
+	// handled synchronously so duplicate orders can be reported back
+	return trk.HandlePurchaseEvent(view.PurchaseEvent{
+		BaseEvent: &view.BaseEvent{Event: view.CART_PURCHASE, SessionId: sessionId, TimeStamp: time.Now().Unix()},
+		OrderId:   data.OrderId,
+		Revenue:   data.Revenue,
+		Currency:  data.Currency,
+		Items:     data.Items,
+	}, r)
+}
+
 func TrackCart(r *http.Request, sessionId int64, trk view.TrackingHandler) error {
 
 	var data CartData
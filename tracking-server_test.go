@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/matst80/slask-tracking/pkg/view"
+)
+
+func postTrack(handler view.TrackingHandler, fn func(r *http.Request, sessionId int64, trk view.TrackingHandler) error, body string) int {
+	recorder := httptest.NewRecorder()
+	TrackHandler(handler, fn)(recorder, httptest.NewRequest(http.MethodPost, "/track", strings.NewReader(body)))
+	return recorder.Code
+}
+
+func postSearch(handler view.TrackingHandler, body string) int {
+	return postTrack(handler, TrackSearch, body)
+}
+
+func TestTrackSearchRejectsInvalidEvents(t *testing.T) {
+	handler := &recordingHandler{}
+	for name, body := range map[string]string{
+		"negative count": `{"query":"tv","noi":-1}`,
+		"negative page":  `{"query":"tv","noi":3,"page":-1}`,
+		"empty query":    `{"noi":3}`,
+		"malformed":      `{"query":`,
+	} {
+		if code := postSearch(handler, body); code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", name, code)
+		}
+	}
+	if len(handler.events) != 0 {
+		t.Errorf("Expected no events to be handled, got %+v", handler.events)
+	}
+}
+
+func TestTrackSearchKeepsClientFields(t *testing.T) {
+	handler := &recordingHandler{}
+	code := postSearch(handler, `{"query":"tv","noi":3,"country":"se","context":"search","ts":1700000000,"session_id":99,"event":7}`)
+	if code != http.StatusAccepted {
+		t.Fatalf("Expected 202, got %d", code)
+	}
+	search := handler.wait(t, 1)[0].(*view.SearchEvent)
+	if search.Country != "se" || search.Context != "search" || search.TimeStamp != 1700000000 {
+		t.Errorf("Expected client fields to be kept, got %+v", search.BaseEvent)
+	}
+	if search.Event != view.EVENT_SEARCH || search.SessionId != 0 {
+		t.Errorf("Expected event type and session to be set by the server, got %+v", search.BaseEvent)
+	}
+}
+
+func TestTrackSuggestKeepsCountry(t *testing.T) {
+	handler := &recordingHandler{}
+	code := postTrack(handler, TrackSuggest, `{"value":"tv b","suggestions":4,"results":12,"country":"no","context":"header","ts":1}`)
+	if code != http.StatusAccepted {
+		t.Fatalf("Expected 202, got %d", code)
+	}
+	suggest := handler.wait(t, 1)[0].(*view.SuggestEvent)
+	if suggest.Country != "no" || suggest.Context != "header" || suggest.Value != "tv b" || suggest.Results != 12 {
+		t.Errorf("Expected client fields to be kept, got %+v %+v", suggest, suggest.BaseEvent)
+	}
+	if suggest.Event != view.EVENT_SUGGEST || suggest.TimeStamp == 1 {
+		t.Errorf("Expected event type and timestamp to be set by the server, got %+v", suggest.BaseEvent)
+	}
+}
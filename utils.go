@@ -93,3 +93,18 @@ func JsonHandler(handler func(w http.ResponseWriter, r *http.Request) (interface
 		}
 	}
 }
+
+// parseTime reads unix seconds or a date, an empty value is zero
+func parseTime(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	if ts, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return ts, nil
+	}
+	t, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %s, expected unix seconds or yyyy-mm-dd", value)
+	}
+	return t.Unix(), nil
+}